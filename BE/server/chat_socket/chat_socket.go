@@ -1,86 +1,823 @@
 package chat_socket
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"tukarkultur/api/middleware"
+	"tukarkultur/api/models"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+// Time a connection that didn't authenticate during the handshake has to
+// send its auth frame
+const authWait = 10 * time.Second
 
-type Client struct {
-	name string
-	conn *websocket.Conn
+// Authenticator resolves session tokens and applies the account policies of
+// the REST routes, see middleware.AuthMiddleware
+type Authenticator interface {
+	Authenticate(token, clientIP string) (*models.AuthSession, *models.User, error)
+	CheckVerifiedEmail(userID uuid.UUID) error
 }
 
-func NewClient(name string, conn *websocket.Conn) *Client {
-	return &Client{name, conn}
-}
+// Frame types. Frames without a type are chat messages.
+const (
+	// Client → server: resend everything after last_seq, answered by the
+	// missed messages and a synced frame
+	FrameSync = "sync"
+
+	// Both ways: the client sends {"type": "read", "id": ...} once it showed
+	// a message, which marks it and everything before it in the
+	// conversation as read. The reader's devices, and the sender unless the
+	// reader turned read receipts off, get it back with read_at set.
+	FrameRead = "read"
+
+	// Both ways: {"type": "typing_start", "conversation_id": ...} while the
+	// user is typing and typing_stop when they stop or send. Passed on to the
+	// other participants' connections with sender set, and never stored.
+	FrameTypingStart = "typing_start"
+	FrameTypingStop  = "typing_stop"
 
-var clients = make(map[*Client]bool)
-var broadcast = make(chan Message)
+	// Client → server: {"type": "meetup_accept", "meetup_id": ...} accepts
+	// the meetup proposed in a meetup_proposal message. Everyone in the
+	// conversation gets a meetup_updated frame with the confirmed meetup.
+	FrameMeetupAccept = "meetup_accept"
 
+	// Both ways: {"type": "edit", "id": ..., "text": ...} replaces the text
+	// of a message the user sent. Everyone who got the message gets the frame
+	// back with edited_at set.
+	FrameEdit = "edit"
+
+	// Both ways: {"type": "delete", "id": ...} deletes a message for the
+	// user's own devices. With "for_everyone": true the sender deletes it for
+	// every recipient, within models.DeleteForEveryoneWindow of sending it;
+	// they get the frame back with deleted_at set and the content is gone.
+	FrameDelete = "delete"
+
+	// Both ways: {"type": "react", "id": ..., "emoji": ...} adds an emoji
+	// reaction to a message and unreact takes it back. Everyone who got the
+	// message gets the frame back with user_id set.
+	FrameReact   = "react"
+	FrameUnreact = "unreact"
+
+	// Server → client
+	FrameMessage       = "message"
+	FrameSent          = "sent"           // the stored copy of a message, back to the connection that sent it
+	FrameSynced        = "synced"         // backlog sent up to last_seq
+	FrameDelivered     = "delivered"      // a message reached one of the receiver's devices
+	FramePresence      = "presence"       // a friend came online or went offline
+	FrameMeetupUpdated = "meetup_updated" // a meetup proposed in the conversation changed
+	FrameTranslation   = "translation"    // a message's text in the receiver's preferred language, see Hub.translate
+	FrameError         = "error"          // a frame was rejected, with client_id echoed and the reason in error
+)
+
+// Message is the WebSocket frame, also sent as the data of event stream
+// events and posted to HandleFrame. Clients send conversation_id (or, for a
+// one-to-one chat, receiver), message_type and the payload of that type: text
+// for text messages; image_url and image_public_id from POST /chat/images
+// for images; location for location pins; meetup with location_name,
+// location_address and meetup_time for meetup proposals, which creates the
+// meetup. Text is an optional caption on the others. The server sets sender
+// to the authenticated user and fills in the rest once the message is
+// stored; receiver is only set in one-to-one chats. Seq is the message's position in the
+// receiving user's inbox: clients dedupe on it and send the highest one they
+// have in a sync frame after reconnecting. ClientID is an optional client
+// generated ID echoed in the sent frame, to match it with the local copy.
+// Receipt frames carry the message's id, conversation, sender and receiver
+// plus delivered_at or read_at; presence frames carry user_id, online and
+// last_seen_at. Edits, deletions and reactions only go to connected devices;
+// the others see them in the message when they load the history. Users who
+// turned translation on in the conversation get each message and edit in
+// two frames: the message frame with the original text right away, then a
+// translation frame once the text is translated, with its id,
+// conversation_id and edited_at, the original in text and translation set
+// to the text in their preferred language. Clients drop a translation frame
+// if the message was edited since. No translation frame comes when the text
+// is already in that language or translating fails or takes too long.
+// Messages from the backlog carry their translation already.
 type Message struct {
-	Sender    string `json:"sender"`
-	Receiver  string `json:"receiver"`
-	Text      string `json:"text"`
-	Image_url string `json:"image_url"`
+	Type           string                     `json:"type,omitempty"`
+	ID             *uuid.UUID                 `json:"id,omitempty"`
+	ClientID       string                     `json:"client_id,omitempty"`
+	Seq            int64                      `json:"seq,omitempty"`
+	LastSeq        *int64                     `json:"last_seq,omitempty"`
+	ConversationID *uuid.UUID                 `json:"conversation_id,omitempty"`
+	Sender         string                     `json:"sender,omitempty"`
+	Receiver       string                     `json:"receiver,omitempty"`
+	Text           string                     `json:"text,omitempty"`
+	Image_url      string                     `json:"image_url,omitempty"`
+	MessageType    string                     `json:"message_type,omitempty"`
+	ImagePublicID  string                     `json:"image_public_id,omitempty"`
+	Location       *models.MessageLocation    `json:"location,omitempty"`
+	MeetupID       *uuid.UUID                 `json:"meetup_id,omitempty"`
+	Meetup         *models.Meetup             `json:"meetup,omitempty"`
+	CreatedAt      *time.Time                 `json:"created_at,omitempty"`
+	DeliveredAt    *time.Time                 `json:"delivered_at,omitempty"`
+	ReadAt         *time.Time                 `json:"read_at,omitempty"`
+	UserID         string                     `json:"user_id,omitempty"`
+	Online         *bool                      `json:"online,omitempty"`
+	LastSeenAt     *time.Time                 `json:"last_seen_at,omitempty"`
+	EditedAt       *time.Time                 `json:"edited_at,omitempty"`
+	DeletedAt      *time.Time                 `json:"deleted_at,omitempty"`
+	ForEveryone    bool                       `json:"for_everyone,omitempty"`
+	Emoji          string                     `json:"emoji,omitempty"`
+	Translation    *models.MessageTranslation `json:"translation,omitempty"`
+	Error          string                     `json:"error,omitempty"`
 }
 
-func HandleConnection(c *gin.Context) {
-	client_id := c.Query("id")
-	if client_id == "" {
-		log.Println("Invalid: No Client ID")
-		return
+// authFrame is the first frame sent by clients that can't set an
+// Authorization header on the handshake (e.g. browsers):
+// {"type": "auth", "token": "<session token>"}
+type authFrame struct {
+	Type   string     `json:"type"`
+	Token  string     `json:"token,omitempty"`
+	UserID *uuid.UUID `json:"user_id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// HandleConnection upgrades the request to a WebSocket bound to the
+// authenticated user and registers it with the hub. A bearer token on the
+// handshake is checked before upgrading; otherwise the first frame must be
+// an auth frame, answered with {"type": "authenticated", "user_id": ...}.
+func (h *Hub) HandleConnection(c *gin.Context) {
+	var session *models.AuthSession
+	var user *models.User
+	if token := middleware.BearerToken(c); token != "" {
+		var err error
+		session, user, err = h.auth.Authenticate(token, c.ClientIP())
+		if err != nil {
+			switch {
+			case errors.Is(err, middleware.ErrInvalidToken):
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			case errors.Is(err, middleware.ErrAccountSuspended):
+				c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate session"})
+			}
+			return
+		}
 	}
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("Upgrader Error: ", err)
 		return
 	}
-	defer conn.Close()
 
-	client := NewClient(client_id, conn)
-	clients[client] = true
-
-	for {
-		var msg Message
-		err := client.conn.ReadJSON(&msg)
+	if user == nil {
+		session, user, err = h.authenticateFirstFrame(conn, c.ClientIP())
 		if err != nil {
-			log.Println("Read JSON: ", err)
-			delete(clients, client)
+			closeWithError(conn, websocket.ClosePolicyViolation, err.Error())
 			return
 		}
+	}
 
-		broadcast <- msg
+	client := NewClient(h, user.ID, session, c.ClientIP(), conn)
+	if !h.Register(client) {
+		closeWithError(conn, websocket.CloseGoingAway, "server shutting down")
+		return
 	}
+
+	h.presence.Connect(user.ID)
+	go client.writePump()
+	client.readPump()
 }
 
-func handleMessages() {
-	for {
-		msg := <-broadcast
+// authenticateFirstFrame waits for the client's auth frame and acknowledges it
+func (h *Hub) authenticateFirstFrame(conn *websocket.Conn, clientIP string) (*models.AuthSession, *models.User, error) {
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(authWait))
+
+	var frame authFrame
+	if err := conn.ReadJSON(&frame); err != nil {
+		return nil, nil, errors.New("authentication required")
+	}
+	if frame.Type != "auth" || frame.Token == "" {
+		return nil, nil, errors.New("first frame must be an auth frame")
+	}
 
-		for client := range clients {
-			if client.name == msg.Receiver {
-				err := client.conn.WriteJSON(msg)
-				if err != nil {
-					fmt.Println(err)
-					client.conn.Close()
-					delete(clients, client)
-				}
-				break
+	session, user, err := h.auth.Authenticate(frame.Token, clientIP)
+	if err != nil {
+		if errors.Is(err, middleware.ErrInvalidToken) || errors.Is(err, middleware.ErrAccountSuspended) {
+			return nil, nil, err
+		}
+		log.Println("Chat Auth: ", err)
+		return nil, nil, errors.New("failed to validate session")
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteJSON(authFrame{Type: "authenticated", UserID: &user.ID}); err != nil {
+		return nil, nil, err
+	}
+	return session, user, nil
+}
+
+func closeWithError(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(writeWait))
+	conn.Close()
+}
+
+// newOriginChecker allows handshakes from the comma-separated origins in
+// CHAT_ALLOWED_ORIGINS ("*" allows any) and from the API's own host.
+// Requests without an Origin header come from native apps, not browsers,
+// and are let through since they can't be used for cross-site hijacking.
+func newOriginChecker() func(r *http.Request) bool {
+	allowed := make(map[string]bool)
+	for _, origin := range strings.Split(os.Getenv("CHAT_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed[strings.TrimSuffix(strings.ToLower(origin), "/")] = true
+		}
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || allowed["*"] || allowed[strings.ToLower(origin)] {
+			return true
+		}
+
+		parsed, err := url.Parse(origin)
+		if err == nil && strings.EqualFold(parsed.Host, r.Host) {
+			return true
+		}
+
+		log.Printf("Rejected chat connection from origin %s", origin)
+		return false
+	}
+}
+
+// conversationFor resolves the conversation a frame from client is meant
+// for: its conversation_id, or the direct conversation with its receiver,
+// which is created on the first message. The client must be a participant.
+func (h *Hub) conversationFor(client *Client, msg Message) (*models.Conversation, error) {
+	var conversation *models.Conversation
+	switch {
+	case msg.ConversationID != nil:
+		var err error
+		conversation, err = h.conversationRepo.GetByID(*msg.ConversationID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("%w: conversation not found", models.ErrInvalidMessage)
 			}
+			return nil, err
+		}
+	case msg.Receiver != "":
+		receiverID, err := uuid.Parse(msg.Receiver)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid receiver %q", models.ErrInvalidMessage, msg.Receiver)
+		}
+		conversation, err = h.conversationRepo.EnsureDirect(client.userID, receiverID)
+		if err != nil {
+			return nil, err
+		}
+		if !conversation.IsParticipant(receiverID) {
+			return nil, fmt.Errorf("%w: receiver not found", models.ErrInvalidMessage)
+		}
+	default:
+		return nil, fmt.Errorf("%w: conversation_id or receiver is required", models.ErrInvalidMessage)
+	}
+
+	if !conversation.IsParticipant(client.userID) {
+		return nil, fmt.Errorf("%w: you are not a participant of this conversation", models.ErrInvalidMessage)
+	}
+	return conversation, nil
+}
+
+// handleFrame processes a frame from client other than sync, logging what
+// goes wrong. It returns the error the client should be told about, if any,
+// and for chat messages the stored message's sent frame.
+func (h *Hub) handleFrame(client *Client, msg Message) (*Message, error) {
+	var err error
+	switch msg.Type {
+	case FrameTypingStart, FrameTypingStop:
+		if err := h.relayTyping(client, msg); err != nil {
+			log.Println("Relay Typing: ", err)
+		}
+		return nil, nil
+	case FrameRead:
+		if err := h.markRead(client, msg); err != nil {
+			log.Println("Mark Read: ", err)
+		}
+		return nil, nil
+	case FrameMeetupAccept:
+		if err = h.acceptMeetup(client, msg); err != nil {
+			log.Println("Accept Meetup: ", err)
+		}
+		return nil, err
+	case FrameEdit:
+		if err = h.editMessage(client, msg); err != nil {
+			log.Println("Edit Message: ", err)
+		}
+		return nil, err
+	case FrameDelete:
+		if err = h.deleteMessage(client, msg); err != nil {
+			log.Println("Delete Message: ", err)
+		}
+		return nil, err
+	case FrameReact, FrameUnreact:
+		if err = h.react(client, msg); err != nil {
+			log.Println("React: ", err)
+		}
+		return nil, err
+	case "", FrameMessage:
+	default:
+		log.Printf("Rejecting chat frame of unknown type %q", msg.Type)
+		return nil, fmt.Errorf("%w: unknown frame type %q", models.ErrInvalidMessage, msg.Type)
+	}
+
+	seqs, err := h.saveMessage(client, &msg)
+	if err != nil {
+		log.Println("Save Message: ", err)
+		return nil, err
+	}
+
+	h.Broadcast(msg, seqs, client)
+	msg.Type = FrameSent
+	return &msg, nil
+}
+
+// saveMessage validates msg as sent by client, stores it and replaces it with
+// the stored message's frame. Meetup proposals create their meetup first. It
+// returns the message's sequence number per inbox and starts translating it,
+// see translate.
+func (h *Hub) saveMessage(client *Client, msg *Message) (map[uuid.UUID]int64, error) {
+	conversation, err := h.conversationFor(client, *msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never trust the sender claimed by the client
+	message := &models.Message{
+		ConversationID: conversation.ID,
+		SenderID:       client.userID,
+		MessageType:    msg.MessageType,
+	}
+	if conversation.Type == models.ConversationTypeDirect {
+		message.ReceiverID = directPeer(conversation, client.userID)
+	}
+	if message.MessageType == "" {
+		// Older clients only send text or image_url
+		message.MessageType = models.MessageTypeText
+		if msg.Image_url != "" && msg.Text == "" {
+			message.MessageType = models.MessageTypeImage
+		}
+	}
+	if msg.Text != "" {
+		message.MessageText = &msg.Text
+	}
+
+	switch message.MessageType {
+	case models.MessageTypeImage:
+		if !strings.HasPrefix(msg.ImagePublicID, models.ChatImageFolder(client.userID)+"/") ||
+			!h.cloudinary.IsOwnImage(msg.Image_url, msg.ImagePublicID) {
+			return nil, fmt.Errorf("%w: images must be uploaded through /chat/images first", models.ErrInvalidMessage)
+		}
+		message.ImageURL = &msg.Image_url
+		message.CloudinaryPublicID = &msg.ImagePublicID
+	case models.MessageTypeLocation:
+		message.Location = msg.Location
+	case models.MessageTypeMeetupProposal:
+		if err := validateMeetupProposal(msg.Meetup); err != nil {
+			return nil, err
+		}
+		// Like POST /meetups, proposing one may need a verified email
+		if err := h.auth.CheckVerifiedEmail(client.userID); err != nil {
+			if errors.Is(err, middleware.ErrEmailNotVerified) {
+				return nil, fmt.Errorf("%w: %v", models.ErrInvalidMessage, err)
+			}
+			return nil, err
+		}
+		// Proposals in a group are open to any participant
+		message.Meetup = &models.Meetup{
+			ProposedBy:      client.userID,
+			ProposedTo:      message.ReceiverID,
+			LocationName:    msg.Meetup.LocationName,
+			LocationAddress: msg.Meetup.LocationAddress,
+			MeetupTime:      msg.Meetup.MeetupTime,
+		}
+	}
+
+	if err := message.Validate(); err != nil {
+		return nil, err
+	}
+
+	original := message.MessageText
+	var moderation *models.ModerationResult
+	message.MessageText, moderation, err = h.moderate(client, conversation.ID, original)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.Meetup != nil {
+		if err := h.meetupRepo.Create(message.Meetup); err != nil {
+			return nil, err
+		}
+		message.MeetupID = &message.Meetup.ID
+	}
+
+	seqs, err := h.messageRepo.Create(message, conversation.ParticipantIDs())
+	if err != nil {
+		if message.Meetup != nil {
+			if err := h.meetupRepo.Delete(message.Meetup.ID); err != nil {
+				log.Printf("Error removing meetup %s of unsent proposal: %v", message.Meetup.ID, err)
+			}
+		}
+		return nil, err
+	}
+	if original != nil {
+		h.recordFlag(client.userID, conversation.ID, &message.ID, *original, moderation)
+	}
+
+	clientID := msg.ClientID
+	*msg = newMessageFrame(message)
+	msg.ClientID = clientID
+	h.translate(message)
+	return seqs, nil
+}
+
+// directPeer returns the other participant of a direct conversation, or
+// userID itself in a conversation with themselves
+func directPeer(conversation *models.Conversation, userID uuid.UUID) *uuid.UUID {
+	for _, participant := range conversation.Participants {
+		if participant.UserID != userID {
+			return &participant.UserID
+		}
+	}
+	return &userID
+}
+
+// validateMeetupProposal checks the meetup of a meetup_proposal message
+func validateMeetupProposal(meetup *models.Meetup) error {
+	if meetup == nil {
+		return fmt.Errorf("%w: meetup is required", models.ErrInvalidMessage)
+	}
+	if meetup.LocationName != nil && utf8.RuneCountInString(*meetup.LocationName) > models.MaxLocationNameLength {
+		return fmt.Errorf("%w: meetup location name is longer than %d characters", models.ErrInvalidMessage, models.MaxLocationNameLength)
+	}
+	if meetup.MeetupTime != nil && meetup.MeetupTime.Before(time.Now()) {
+		return fmt.Errorf("%w: meetup time is in the past", models.ErrInvalidMessage)
+	}
+	return nil
+}
+
+// acceptMeetup handles a meetup_accept frame, confirming the meetup the way
+// PUT /meetups/:id/confirm does, and tells everyone in the conversation it
+// was proposed in
+func (h *Hub) acceptMeetup(client *Client, msg Message) error {
+	if msg.MeetupID == nil {
+		return fmt.Errorf("%w: meetup_id is required", models.ErrInvalidMessage)
+	}
+
+	meetup, err := h.meetupRepo.GetByID(*msg.MeetupID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: meetup not found", models.ErrInvalidMessage)
+		}
+		return err
+	}
+
+	conversationID, err := h.messageRepo.GetMeetupConversationID(meetup.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: meetup was not proposed in a chat", models.ErrInvalidMessage)
 		}
+		return err
+	}
+	conversation, err := h.conversationRepo.GetByID(conversationID)
+	if err != nil {
+		return err
+	}
+
+	if meetup.ProposedBy == client.userID || !conversation.IsParticipant(client.userID) ||
+		(meetup.ProposedTo != nil && *meetup.ProposedTo != client.userID) {
+		return fmt.Errorf("%w: only the invited users can accept this meetup", models.ErrInvalidMessage)
+	}
+	if meetup.Status != "proposed" {
+		return fmt.Errorf("%w: meetup is already %s", models.ErrInvalidMessage, meetup.Status)
 	}
+
+	// Another participant may have accepted, or the proposer cancelled,
+	// since the meetup was loaded
+	meetup, err = h.meetupRepo.Confirm(meetup.ID, client.userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: meetup is no longer proposed", models.ErrInvalidMessage)
+		}
+		return err
+	}
+
+	h.Notify(Message{
+		Type:           FrameMeetupUpdated,
+		ConversationID: &conversation.ID,
+		MeetupID:       &meetup.ID,
+		Meetup:         meetup,
+	}, conversation.ParticipantIDs()...)
+	return nil
 }
 
-func Run() {
-	go handleMessages()
+// newMessageFrame converts a stored message to its frame
+func newMessageFrame(message *models.Message) Message {
+	msg := Message{
+		Type:           FrameMessage,
+		ID:             &message.ID,
+		Seq:            message.Seq,
+		ConversationID: &message.ConversationID,
+		Sender:         message.SenderID.String(),
+		CreatedAt:      &message.CreatedAt,
+		DeliveredAt:    message.DeliveredAt,
+		ReadAt:         message.ReadAt,
+		MessageType:    message.MessageType,
+		Location:       message.Location,
+		MeetupID:       message.MeetupID,
+		Meetup:         message.Meetup,
+		EditedAt:       message.EditedAt,
+		DeletedAt:      message.DeletedAt,
+		Translation:    message.Translation,
+	}
+	if message.ReceiverID != nil {
+		msg.Receiver = message.ReceiverID.String()
+	}
+	if message.MessageText != nil {
+		msg.Text = *message.MessageText
+	}
+	if message.ImageURL != nil {
+		msg.Image_url = *message.ImageURL
+	}
+	return msg
+}
+
+// newReceiptFrame converts a delivered or read receipt to its frame
+func newReceiptFrame(frameType string, receipt *models.MessageReceipt) Message {
+	msg := Message{
+		Type:           frameType,
+		ID:             &receipt.MessageID,
+		ConversationID: &receipt.ConversationID,
+		Sender:         receipt.SenderID.String(),
+		Receiver:       receipt.ReceiverID.String(),
+	}
+	if frameType == FrameRead {
+		msg.ReadAt = &receipt.At
+	} else {
+		msg.DeliveredAt = &receipt.At
+	}
+	return msg
+}
+
+// markRead handles a read frame from client and sends out the receipts, one
+// per sender whose messages it marked
+func (h *Hub) markRead(client *Client, msg Message) error {
+	if msg.ID == nil {
+		return errors.New("read frame without message id")
+	}
+
+	receipts, err := h.messageRepo.MarkRead(client.userID, *msg.ID)
+	if err != nil || len(receipts) == 0 {
+		return err
+	}
+
+	settings, err := h.userRepo.GetSettings(client.userID)
+	if err != nil {
+		return err
+	}
+
+	for _, receipt := range receipts {
+		recipients := []uuid.UUID{client.userID}
+		if settings.ReadReceipts {
+			recipients = append(recipients, receipt.SenderID)
+		}
+		h.Notify(newReceiptFrame(FrameRead, receipt), recipients...)
+	}
+	return nil
+}
+
+// relayTyping passes a typing frame from client on to the other participants
+// of the conversation
+func (h *Hub) relayTyping(client *Client, msg Message) error {
+	conversation, err := h.conversationFor(client, msg)
+	if err != nil {
+		return err
+	}
+
+	frame := Message{
+		Type:           msg.Type,
+		ConversationID: &conversation.ID,
+		Sender:         client.userID.String(),
+	}
+	var recipients []uuid.UUID
+	for _, id := range conversation.ParticipantIDs() {
+		if id != client.userID {
+			recipients = append(recipients, id)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+	if conversation.Type == models.ConversationTypeDirect {
+		frame.Receiver = recipients[0].String()
+	}
+
+	h.Notify(frame, recipients...)
+	return nil
+}
+
+// messageFor loads the message a frame from client refers to by id. The
+// message must be in the client's inbox.
+func (h *Hub) messageFor(client *Client, msg Message) (*models.Message, error) {
+	if msg.ID == nil {
+		return nil, fmt.Errorf("%w: id is required", models.ErrInvalidMessage)
+	}
+
+	messages, err := h.messageRepo.GetByIDs([]uuid.UUID{*msg.ID}, client.userID)
+	if err != nil {
+		return nil, err
+	}
+	// Messages from before inboxes existed only have a sender and receiver
+	if len(messages) == 0 || (messages[0].Seq == 0 && messages[0].SenderID != client.userID &&
+		(messages[0].ReceiverID == nil || *messages[0].ReceiverID != client.userID)) {
+		return nil, fmt.Errorf("%w: message not found", models.ErrInvalidMessage)
+	}
+	return messages[0], nil
+}
+
+// notifyRecipients sends a frame about message to everyone who got it
+func (h *Hub) notifyRecipients(message *models.Message, msg Message) error {
+	recipients, err := h.messageRepo.GetRecipientIDs(message.ID)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		recipients = append(recipients, message.SenderID)
+		if message.ReceiverID != nil {
+			recipients = append(recipients, *message.ReceiverID)
+		}
+	}
+
+	msg.ID = &message.ID
+	msg.ConversationID = &message.ConversationID
+	msg.Sender = message.SenderID.String()
+	seqs := make(map[uuid.UUID]int64, len(recipients))
+	for _, userID := range recipients {
+		seqs[userID] = 0
+	}
+	h.Broadcast(msg, seqs, nil)
+	return nil
+}
+
+// editMessage handles an edit frame from client
+func (h *Hub) editMessage(client *Client, msg Message) error {
+	message, err := h.messageFor(client, msg)
+	if err != nil {
+		return err
+	}
+	if message.SenderID != client.userID {
+		return fmt.Errorf("%w: only the sender can edit this message", models.ErrInvalidMessage)
+	}
+	if message.DeletedAt != nil {
+		return fmt.Errorf("%w: message was deleted", models.ErrInvalidMessage)
+	}
+
+	message.MessageText = nil
+	if msg.Text != "" {
+		message.MessageText = &msg.Text
+	}
+	if err := message.Validate(); err != nil {
+		return err
+	}
+
+	original := message.MessageText
+	var moderation *models.ModerationResult
+	message.MessageText, moderation, err = h.moderate(client, message.ConversationID, original)
+	if err != nil {
+		return err
+	}
+
+	if err := h.messageRepo.Edit(message); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: message was deleted", models.ErrInvalidMessage)
+		}
+		return err
+	}
+	if original != nil {
+		h.recordFlag(client.userID, message.ConversationID, &message.ID, *original, moderation)
+	}
+
+	frame := Message{Type: FrameEdit, EditedAt: message.EditedAt}
+	if message.MessageText != nil {
+		frame.Text = *message.MessageText
+	}
+	if err := h.notifyRecipients(message, frame); err != nil {
+		return err
+	}
+	h.translate(message)
+	return nil
+}
+
+// deleteMessage handles a delete frame from client. Deleting an image
+// message for everyone removes the image from Cloudinary too, unless
+// another message still shows it.
+func (h *Hub) deleteMessage(client *Client, msg Message) error {
+	message, err := h.messageFor(client, msg)
+	if err != nil {
+		return err
+	}
+
+	if !msg.ForEveryone {
+		if err := h.messageRepo.Hide(message.ID, client.userID); err != nil {
+			return err
+		}
+		h.Notify(Message{
+			Type:           FrameDelete,
+			ID:             &message.ID,
+			ConversationID: &message.ConversationID,
+			Sender:         message.SenderID.String(),
+		}, client.userID)
+		return nil
+	}
+
+	if message.SenderID != client.userID {
+		return fmt.Errorf("%w: only the sender can delete this message for everyone", models.ErrInvalidMessage)
+	}
+	if message.DeletedAt != nil {
+		return fmt.Errorf("%w: message was already deleted", models.ErrInvalidMessage)
+	}
+	if time.Since(message.CreatedAt) > models.DeleteForEveryoneWindow {
+		return fmt.Errorf("%w: messages can only be deleted for everyone within %d minutes of sending them",
+			models.ErrInvalidMessage, int(models.DeleteForEveryoneWindow.Minutes()))
+	}
+
+	publicID := message.CloudinaryPublicID
+	if err := h.messageRepo.DeleteForEveryone(message); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: message was already deleted", models.ErrInvalidMessage)
+		}
+		return err
+	}
+
+	if publicID != nil {
+		go h.deleteImage(*publicID)
+	}
+
+	return h.notifyRecipients(message, Message{
+		Type:        FrameDelete,
+		DeletedAt:   message.DeletedAt,
+		ForEveryone: true,
+	})
+}
+
+// deleteImage removes a deleted message's image from Cloudinary once no
+// message refers to it anymore
+func (h *Hub) deleteImage(publicID string) {
+	inUse, err := h.messageRepo.IsImageInUse(publicID)
+	if err != nil {
+		log.Printf("Error checking uses of image %s: %v", publicID, err)
+		return
+	}
+	if inUse {
+		return
+	}
+	if err := h.cloudinary.DeleteImage(publicID); err != nil {
+		log.Printf("Error deleting image %s: %v", publicID, err)
+	}
+}
+
+// react handles a react or unreact frame from client
+func (h *Hub) react(client *Client, msg Message) error {
+	if err := models.ValidateReaction(msg.Emoji); err != nil {
+		return err
+	}
+
+	message, err := h.messageFor(client, msg)
+	if err != nil {
+		return err
+	}
+	if message.DeletedAt != nil {
+		return fmt.Errorf("%w: message was deleted", models.ErrInvalidMessage)
+	}
+
+	var changed bool
+	if msg.Type == FrameReact {
+		changed, err = h.messageRepo.AddReaction(message.ID, client.userID, msg.Emoji)
+	} else {
+		changed, err = h.messageRepo.RemoveReaction(message.ID, client.userID, msg.Emoji)
+	}
+	if err != nil || !changed {
+		return err
+	}
+
+	return h.notifyRecipients(message, Message{
+		Type:   msg.Type,
+		UserID: client.userID.String(),
+		Emoji:  msg.Emoji,
+	})
 }
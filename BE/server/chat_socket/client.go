@@ -0,0 +1,273 @@
+package chat_socket
+
+import (
+	"errors"
+	"log"
+	"time"
+	"tukarkultur/api/middleware"
+	"tukarkultur/api/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a frame to the peer
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong from the peer
+	pongWait = 60 * time.Second
+
+	// Pings are sent at this interval, which must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+
+	// Largest frame accepted from the peer, enough for a text of
+	// models.MaxMessageTextLength in any script. Larger frames close the
+	// connection.
+	maxMessageSize = 32 * 1024
+
+	// Frames queued per client before it is considered too slow
+	sendBufferSize = 64
+
+	// Messages loaded per query when replaying an inbox backlog
+	backlogPageSize = 100
+)
+
+// Client is one authenticated connection, a WebSocket or an event stream
+// (see HandleEventStream). A WebSocket's read pump feeds the hub; its write
+// pump, or the stream pump of an event stream, is the only goroutine that
+// writes to the connection.
+type Client struct {
+	id               string // identifies the connection across instances
+	hub              *Hub
+	userID           uuid.UUID
+	sessionToken     string // rechecked on every ping, see sessionEnded
+	sessionExpiresAt time.Time
+	clientIP         string
+	conn             *websocket.Conn // nil for event streams
+	writeFrame       func(msg Message) error
+	send             chan Message
+	syncRequests     chan int64 // last seen seq from the client's sync frames
+}
+
+func NewClient(hub *Hub, userID uuid.UUID, session *models.AuthSession, clientIP string, conn *websocket.Conn) *Client {
+	return &Client{
+		id:               uuid.NewString(),
+		hub:              hub,
+		userID:           userID,
+		sessionToken:     session.Token,
+		sessionExpiresAt: session.ExpiresAt,
+		clientIP:         clientIP,
+		conn:             conn,
+		writeFrame: func(msg Message) error {
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			return conn.WriteJSON(msg)
+		},
+		send:         make(chan Message, sendBufferSize),
+		syncRequests: make(chan int64, 1),
+	}
+}
+
+// readPump reads frames until the connection fails or goes quiet for longer
+// than pongWait, then unregisters the client.
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.Unregister(c)
+		c.conn.Close()
+		c.hub.presence.Disconnect(c.userID)
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	limiter := newRateLimiter()
+
+	for {
+		var msg Message
+		err := c.conn.ReadJSON(&msg)
+		if err != nil {
+			switch {
+			case errors.Is(err, websocket.ErrReadLimit):
+				// The connection already sent a message too big close frame
+				log.Printf("Closing chat connection of user %s: frame larger than %d bytes", c.userID, maxMessageSize)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure):
+				log.Println("Read JSON: ", err)
+			}
+			return
+		}
+
+		// The connection lives only as long as the session it was opened with
+		if time.Now().After(c.sessionExpiresAt) {
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session expired"),
+				time.Now().Add(writeWait))
+			return
+		}
+
+		if allowed, exhausted := limiter.allow(msg.Type, time.Now()); !allowed {
+			if exhausted {
+				log.Printf("Closing chat connection of user %s: rate limit exceeded", c.userID)
+				c.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded"),
+					time.Now().Add(writeWait))
+				return
+			}
+			c.replyError(msg, errRateLimited)
+			continue
+		}
+
+		if msg.Type == FrameSync {
+			var lastSeq int64
+			if msg.LastSeq != nil {
+				lastSeq = *msg.LastSeq
+			}
+			// One pending sync at a time; the client can ask again after synced
+			select {
+			case c.syncRequests <- lastSeq:
+			default:
+			}
+			continue
+		}
+
+		if _, err := c.hub.handleFrame(c, msg); err != nil {
+			c.replyError(msg, err)
+		}
+	}
+}
+
+// replyError tells the client why its frame was rejected. Only validation
+// and rate limit errors are spelled out; anything else is the server's
+// fault.
+func (c *Client) replyError(frame Message, err error) {
+	reason := "failed to process frame"
+	if errors.Is(err, models.ErrInvalidMessage) || errors.Is(err, errRateLimited) {
+		reason = err.Error()
+	}
+	c.hub.Reply(c, Message{Type: FrameError, ClientID: frame.ClientID, Error: reason})
+}
+
+// writePump first flushes messages that arrived while the user was offline,
+// then sends queued frames, sync backlogs and periodic pings. It closes the
+// connection once the hub closes the send channel, a write fails or the
+// session ends.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	// Live messages queue up in send meanwhile; clients dedupe on seq
+	if err := c.writeBacklog(0, true); err != nil {
+		log.Println("Flush Inbox: ", err)
+		return
+	}
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				// Removed from the hub
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.write(msg); err != nil {
+				log.Println("Write JSON: ", err)
+				return
+			}
+
+		case lastSeq := <-c.syncRequests:
+			if err := c.writeBacklog(lastSeq, false); err != nil {
+				log.Println("Sync Inbox: ", err)
+				return
+			}
+
+		case <-ticker.C:
+			if reason := c.sessionEnded(); reason != "" {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason))
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sessionEnded returns why the session the client connected with no longer
+// lets it stay connected, or "" if it still does. Besides expiring, the
+// session ends when the user logs out, changes their password, refreshes it
+// or is suspended. Errors fail open so a database outage doesn't drop every
+// connection.
+func (c *Client) sessionEnded() string {
+	if time.Now().After(c.sessionExpiresAt) {
+		return "session expired"
+	}
+
+	_, user, err := c.hub.auth.Authenticate(c.sessionToken, c.clientIP)
+	switch {
+	case errors.Is(err, middleware.ErrInvalidToken):
+		return "session revoked"
+	case errors.Is(err, middleware.ErrAccountSuspended):
+		return "account suspended"
+	case err != nil:
+		log.Println("Recheck Session: ", err)
+		return ""
+	case user.ID != c.userID:
+		return "session revoked"
+	}
+	return ""
+}
+
+// write sends one frame, recording message frames as delivered to the user
+// and telling the sender the first time one is
+func (c *Client) write(msg Message) error {
+	if err := c.writeFrame(msg); err != nil {
+		return err
+	}
+
+	if msg.Type == FrameMessage && msg.Seq > 0 {
+		receipt, err := c.hub.messageRepo.MarkDelivered(c.userID, msg.Seq)
+		if err != nil {
+			log.Println("Mark Delivered: ", err)
+		} else if receipt != nil && receipt.SenderID != c.userID {
+			c.hub.Notify(newReceiptFrame(FrameDelivered, receipt), receipt.SenderID)
+		}
+	}
+	return nil
+}
+
+// writeBacklog sends the user's inbox after lastSeq in order. The initial
+// flush (undeliveredOnly) sends just what never reached any device, silently;
+// an explicit sync resends everything and ends with a synced frame.
+func (c *Client) writeBacklog(lastSeq int64, undeliveredOnly bool) error {
+	for {
+		messages, err := c.hub.messageRepo.GetInboxMessages(c.userID, lastSeq, undeliveredOnly, backlogPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			if err := c.write(newMessageFrame(message)); err != nil {
+				return err
+			}
+			lastSeq = message.Seq
+		}
+
+		if len(messages) < backlogPageSize {
+			break
+		}
+	}
+
+	if undeliveredOnly {
+		return nil
+	}
+	return c.write(Message{Type: FrameSynced, LastSeq: &lastSeq})
+}
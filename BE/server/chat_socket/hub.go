@@ -0,0 +1,248 @@
+package chat_socket
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"tukarkultur/api/models"
+	"tukarkultur/api/repository"
+	"tukarkultur/api/services"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Hub owns the set of clients connected to this instance, indexed by user so
+// that every device a user is connected from gets their messages. Messages
+// go out through the pub/sub and come back to the hub of every instance,
+// which delivers them to its own clients. Only the Run goroutine touches the
+// index; connections talk to it through the register, unregister and
+// replies channels.
+type Hub struct {
+	messageRepo      *repository.MessageRepository
+	userRepo         *repository.UserRepository
+	meetupRepo       *repository.MeetupRepository
+	conversationRepo *repository.ConversationRepository
+	moderationRepo   *repository.ModerationRepository
+	presence         *services.PresenceService
+	cloudinary       *services.CloudinaryService
+	filter           services.ContentFilter
+	translator       services.Translator
+	translating      chan struct{}
+	pubsub           PubSub
+	auth             Authenticator
+	upgrader         websocket.Upgrader
+
+	clients    map[uuid.UUID]map[*Client]bool
+	register   chan *Client
+	unregister chan *Client
+	replies    chan reply
+
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// Rate limits of frames posted to HandleFrame, per user
+	postLimitsMu       sync.Mutex
+	postLimits         map[uuid.UUID]*rateLimiter
+	postLimitsPrunedAt time.Time
+}
+
+func NewHub(
+	messageRepo *repository.MessageRepository,
+	userRepo *repository.UserRepository,
+	meetupRepo *repository.MeetupRepository,
+	conversationRepo *repository.ConversationRepository,
+	moderationRepo *repository.ModerationRepository,
+	presence *services.PresenceService,
+	cloudinary *services.CloudinaryService,
+	filter services.ContentFilter,
+	translator services.Translator,
+	pubsub PubSub,
+	auth Authenticator,
+) *Hub {
+	h := &Hub{
+		messageRepo:      messageRepo,
+		userRepo:         userRepo,
+		meetupRepo:       meetupRepo,
+		conversationRepo: conversationRepo,
+		moderationRepo:   moderationRepo,
+		presence:         presence,
+		cloudinary:       cloudinary,
+		filter:           filter,
+		translator:       translator,
+		translating:      make(chan struct{}, translationWorkers()),
+		pubsub:           pubsub,
+		auth:             auth,
+		upgrader:         websocket.Upgrader{CheckOrigin: newOriginChecker()},
+		clients:          make(map[uuid.UUID]map[*Client]bool),
+		register:         make(chan *Client),
+		unregister:       make(chan *Client),
+		replies:          make(chan reply, 256),
+		postLimits:       make(map[uuid.UUID]*rateLimiter),
+		quit:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+	presence.OnChange(h.notifyPresence)
+	return h
+}
+
+// reply is a frame for a single connection of this instance
+type reply struct {
+	client *Client
+	msg    Message
+}
+
+// Run delivers messages until Shutdown is called. It must run in its own
+// goroutine.
+func (h *Hub) Run() {
+	defer close(h.done)
+
+	for {
+		select {
+		case client := <-h.register:
+			if h.clients[client.userID] == nil {
+				h.clients[client.userID] = make(map[*Client]bool)
+			}
+			h.clients[client.userID][client] = true
+
+		case client := <-h.unregister:
+			h.remove(client)
+
+		case event := <-h.pubsub.Events():
+			h.deliver(event)
+
+		case r := <-h.replies:
+			if h.clients[r.client.userID][r.client] {
+				h.send(r.client, r.msg)
+			}
+
+		case <-h.quit:
+			for _, connections := range h.clients {
+				for client := range connections {
+					h.remove(client)
+				}
+			}
+			return
+		}
+	}
+}
+
+// Shutdown disconnects every client, stops Run, waiting for it to finish,
+// and closes the pub/sub. It is safe to call more than once.
+func (h *Hub) Shutdown() {
+	h.stopOnce.Do(func() { close(h.quit) })
+	<-h.done
+	if err := h.pubsub.Close(); err != nil {
+		log.Println("Close PubSub: ", err)
+	}
+}
+
+// Register adds client to the hub, or returns false if the hub has stopped
+func (h *Hub) Register(client *Client) bool {
+	select {
+	case h.register <- client:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// Unregister removes client from the hub. It is a no-op once the hub stopped.
+func (h *Hub) Unregister(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
+}
+
+// Broadcast publishes msg for every connection of the users in seqs (its
+// receiver, and the sender so their other devices stay in sync) on any
+// instance, stamped with each user's sequence number. from is the
+// connection msg arrived on, or nil if it didn't come from a socket. Users
+// without a live connection get the message from their inbox when they
+// reconnect.
+func (h *Hub) Broadcast(msg Message, seqs map[uuid.UUID]int64, from *Client) {
+	event := Event{Message: msg, Seqs: seqs}
+	if from != nil {
+		event.From = from.id
+	}
+	if err := h.pubsub.Publish(event); err != nil && !errors.Is(err, ErrPubSubClosed) {
+		log.Println("Publish: ", err)
+	}
+}
+
+// Notify queues a frame that isn't part of any inbox, like a receipt, for
+// every live connection of userIDs. Offline users don't get it.
+func (h *Hub) Notify(msg Message, userIDs ...uuid.UUID) {
+	seqs := make(map[uuid.UUID]int64, len(userIDs))
+	for _, userID := range userIDs {
+		seqs[userID] = 0
+	}
+	h.Broadcast(msg, seqs, nil)
+}
+
+// Reply queues msg for client alone, e.g. to reject a frame it sent
+func (h *Hub) Reply(client *Client, msg Message) {
+	select {
+	case h.replies <- reply{client: client, msg: msg}:
+	case <-h.done:
+	}
+}
+
+// notifyPresence pushes a presence change to the friends who are connected
+func (h *Hub) notifyPresence(presence models.Presence, friendIDs []uuid.UUID) {
+	h.Notify(Message{
+		Type:       FramePresence,
+		UserID:     presence.UserID.String(),
+		Online:     &presence.Online,
+		LastSeenAt: presence.LastSeenAt,
+	}, friendIDs...)
+}
+
+// deliver sends a published event to the recipients connected here
+func (h *Hub) deliver(event Event) {
+	for userID, seq := range event.Seqs {
+		msg := event.Message
+		msg.Seq = seq
+		// Only the sending connection knows what its client_id refers to
+		msg.ClientID = ""
+
+		for client := range h.clients[userID] {
+			frame := msg
+			if event.From != "" && client.id == event.From {
+				frame.Type = FrameSent
+				frame.ClientID = event.Message.ClientID
+			}
+
+			h.send(client, frame)
+		}
+	}
+}
+
+// send queues msg on client without blocking the hub
+func (h *Hub) send(client *Client, msg Message) {
+	select {
+	case client.send <- msg:
+	default:
+		// The client isn't keeping up; drop it rather than stall everyone
+		log.Printf("Dropping slow chat client of user %s", client.userID)
+		h.remove(client)
+	}
+}
+
+// remove closes the client's send channel, which makes its write pump close
+// the connection
+func (h *Hub) remove(client *Client) {
+	connections := h.clients[client.userID]
+	if _, ok := connections[client]; !ok {
+		return
+	}
+
+	delete(connections, client)
+	if len(connections) == 0 {
+		delete(h.clients, client.userID)
+	}
+	close(client.send)
+}
@@ -0,0 +1,243 @@
+package chat_socket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestHub starts a hub on a local pub/sub. Delivery doesn't touch the
+// repositories, so they are left out.
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h := &Hub{
+		pubsub:     NewLocalPubSub(),
+		clients:    make(map[uuid.UUID]map[*Client]bool),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		replies:    make(chan reply, 256),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go h.Run()
+	t.Cleanup(h.Shutdown)
+	return h
+}
+
+func newTestClient(h *Hub, userID uuid.UUID) *Client {
+	return &Client{
+		id:     uuid.NewString(),
+		hub:    h,
+		userID: userID,
+		send:   make(chan Message, sendBufferSize),
+	}
+}
+
+func registerClient(t *testing.T, h *Hub, client *Client) {
+	t.Helper()
+	if !h.Register(client) {
+		t.Fatal("Register() = false on a running hub")
+	}
+}
+
+// receive waits for the next frame queued for client
+func receive(t *testing.T, client *Client) Message {
+	t.Helper()
+	select {
+	case msg, ok := <-client.send:
+		if !ok {
+			t.Fatal("send channel closed, want a frame")
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a frame")
+	}
+	return Message{}
+}
+
+// expectClosed waits for client's send channel to be closed, skipping
+// frames still queued before it
+func expectClosed(t *testing.T, client *Client) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-client.send:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the send channel to close")
+		}
+	}
+}
+
+// expectNothing checks that nothing is queued for client once the hub
+// handled everything sent before
+func expectNothing(t *testing.T, h *Hub, client *Client) {
+	t.Helper()
+	// A frame for someone else goes through the same queue, so once it is
+	// delivered anything meant for client would have been too
+	marker := newTestClient(h, uuid.New())
+	registerClient(t, h, marker)
+	h.Notify(Message{Type: FramePresence}, marker.userID)
+	receive(t, marker)
+
+	select {
+	case msg, ok := <-client.send:
+		if ok {
+			t.Fatalf("got frame %+v, want none", msg)
+		}
+		t.Fatal("send channel closed, want it open")
+	default:
+	}
+}
+
+func TestHubRegisterUnregister(t *testing.T) {
+	h := newTestHub(t)
+	client := newTestClient(h, uuid.New())
+
+	registerClient(t, h, client)
+	h.Notify(Message{Type: FramePresence}, client.userID)
+	if msg := receive(t, client); msg.Type != FramePresence {
+		t.Fatalf("got %q frame, want %q", msg.Type, FramePresence)
+	}
+
+	h.Unregister(client)
+	expectClosed(t, client)
+
+	// Unregistering twice is harmless
+	h.Unregister(client)
+}
+
+func TestHubDeliversToEveryConnectionOfUser(t *testing.T) {
+	h := newTestHub(t)
+	userID := uuid.New()
+	phone := newTestClient(h, userID)
+	laptop := newTestClient(h, userID)
+	other := newTestClient(h, uuid.New())
+	for _, client := range []*Client{phone, laptop, other} {
+		registerClient(t, h, client)
+	}
+
+	id := uuid.New()
+	h.Broadcast(Message{Type: FrameMessage, ID: &id, ClientID: "local-1"}, map[uuid.UUID]int64{userID: 7}, phone)
+
+	sent := receive(t, phone)
+	if sent.Type != FrameSent || sent.ClientID != "local-1" || sent.Seq != 7 {
+		t.Fatalf("sending connection got %+v, want a sent frame with seq 7 and client_id local-1", sent)
+	}
+	msg := receive(t, laptop)
+	if msg.Type != FrameMessage || msg.ClientID != "" || msg.Seq != 7 {
+		t.Fatalf("other connection got %+v, want a message frame with seq 7 and no client_id", msg)
+	}
+	expectNothing(t, h, other)
+}
+
+func TestHubDropsSlowClient(t *testing.T) {
+	h := newTestHub(t)
+	userID := uuid.New()
+	slow := newTestClient(h, userID)
+	fast := newTestClient(h, userID)
+	registerClient(t, h, slow)
+	registerClient(t, h, fast)
+
+	// Fill both buffers, then make room in the fast client's only
+	for i := 0; i < sendBufferSize; i++ {
+		h.Notify(Message{Type: FramePresence}, userID)
+	}
+	for i := 0; i < sendBufferSize; i++ {
+		receive(t, fast)
+	}
+
+	h.Notify(Message{Type: FramePresence}, userID)
+	receive(t, fast)
+
+	for i := 0; i < sendBufferSize; i++ {
+		if _, ok := <-slow.send; !ok {
+			t.Fatalf("send channel closed after %d frames, want %d queued", i, sendBufferSize)
+		}
+	}
+	if _, ok := <-slow.send; ok {
+		t.Fatal("slow client got a frame past its buffer, want it dropped")
+	}
+}
+
+func TestHubDoesNotBlockAfterShutdown(t *testing.T) {
+	h := newTestHub(t)
+	client := newTestClient(h, uuid.New())
+	registerClient(t, h, client)
+	h.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// More than the replies and pub/sub buffers hold
+		for i := 0; i < 1000; i++ {
+			h.Reply(client, Message{Type: FrameError})
+			h.Notify(Message{Type: FramePresence}, client.userID)
+		}
+		h.Unregister(client)
+		if h.Register(newTestClient(h, uuid.New())) {
+			t.Error("Register() = true after Shutdown")
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("hub calls blocked after Shutdown")
+	}
+}
+
+func TestHubShutdownClosesEveryClient(t *testing.T) {
+	h := newTestHub(t)
+	userID := uuid.New()
+	clients := []*Client{newTestClient(h, userID), newTestClient(h, userID), newTestClient(h, uuid.New())}
+	for _, client := range clients {
+		registerClient(t, h, client)
+	}
+
+	h.Shutdown()
+	for _, client := range clients {
+		expectClosed(t, client)
+	}
+
+	// Safe to call again, as the server's shutdown hook and the cleanup do
+	done := make(chan struct{})
+	go func() {
+		h.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("second Shutdown blocked")
+	}
+}
+
+func TestHubDropsTranslationsPastLimit(t *testing.T) {
+	h := newTestHub(t)
+	h.translating = make(chan struct{}, 2)
+
+	release := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		if !h.startTranslation(func() { <-release }) {
+			t.Fatalf("translation %d not started with a slot free", i+1)
+		}
+	}
+	if h.startTranslation(func() {}) {
+		t.Fatal("translation started with every slot taken, want it dropped")
+	}
+
+	close(release)
+	deadline := time.After(time.Second)
+	for !h.startTranslation(func() {}) {
+		select {
+		case <-deadline:
+			t.Fatal("no slot freed after the running translations finished")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package chat_socket
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"tukarkultur/api/models"
+
+	"github.com/google/uuid"
+)
+
+// Rate limits per connection, or per user for frames posted to HandleFrame.
+// Every frame takes a token from the frame bucket; frames that store
+// something (messages, edits, deletions, reactions, meetup accepts) take one
+// from the message bucket as well. Frames over the limit are rejected, each
+// costing a strike, and a WebSocket is closed once the strikes run out.
+const (
+	frameBurst    = 30
+	frameInterval = 100 * time.Millisecond
+
+	messageBurst    = 10
+	messageInterval = time.Second
+
+	maxStrikes     = 10
+	strikeInterval = 10 * time.Second
+)
+
+var errRateLimited = errors.New("rate limit exceeded, slow down")
+
+// storesFrame reports whether frames of type frameType write to the
+// database, and so count against the message rate limit
+func storesFrame(frameType string) bool {
+	switch frameType {
+	case "", FrameMessage, FrameEdit, FrameDelete, FrameReact, FrameUnreact, FrameMeetupAccept:
+		return true
+	}
+	return false
+}
+
+// tokenBucket allows bursts of up to capacity events, refilling one token
+// every interval. It is not safe for concurrent use.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	interval time.Duration
+	last     time.Time
+}
+
+func newTokenBucket(capacity int, interval time.Duration) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		interval: interval,
+		last:     time.Now(),
+	}
+}
+
+// allow takes a token if there is one
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens = min(b.capacity, b.tokens+float64(now.Sub(b.last))/float64(b.interval))
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter applies the rate limits to the frames of one connection
+type rateLimiter struct {
+	frames   *tokenBucket
+	messages *tokenBucket
+	strikes  *tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		frames:   newTokenBucket(frameBurst, frameInterval),
+		messages: newTokenBucket(messageBurst, messageInterval),
+		strikes:  newTokenBucket(maxStrikes, strikeInterval),
+	}
+}
+
+// allow reports whether a frame of frameType is within the limits and, if
+// it isn't, whether that was the last strike
+func (l *rateLimiter) allow(frameType string, now time.Time) (allowed, exhausted bool) {
+	if l.frames.allow(now) && (!storesFrame(frameType) || l.messages.allow(now)) {
+		return true, false
+	}
+	return false, !l.strikes.allow(now)
+}
+
+// idle reports whether the limiter has been unused long enough to be full
+// again, and so can be dropped
+func (l *rateLimiter) idle(now time.Time) bool {
+	return now.Sub(l.frames.last) > maxStrikes*strikeInterval
+}
+
+// moderate runs the text of a message from client through the content
+// filter and returns the text to store, masked where the filter said so,
+// along with the verdict to pass to recordFlag once the message is stored.
+// Blocked texts are recorded right away and rejected.
+func (h *Hub) moderate(client *Client, conversationID uuid.UUID, text *string) (*string, *models.ModerationResult, error) {
+	if h.filter == nil || text == nil {
+		return text, nil, nil
+	}
+
+	result, err := h.filter.Check(*text)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if result.Action == models.ModerationBlock {
+		h.recordFlag(client.userID, conversationID, nil, *text, result)
+		return nil, nil, fmt.Errorf("%w: message was blocked by the content filter", models.ErrInvalidMessage)
+	}
+	return &result.Text, result, nil
+}
+
+// recordFlag queues a flagged or blocked message for moderator review,
+// keeping its original text
+func (h *Hub) recordFlag(senderID, conversationID uuid.UUID, messageID *uuid.UUID, text string, result *models.ModerationResult) {
+	if result == nil || models.ModerationSeverity(result.Action) < models.ModerationSeverity(models.ModerationFlag) {
+		return
+	}
+
+	flag := &models.FlaggedMessage{
+		MessageID:      messageID,
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		MessageText:    text,
+		Action:         result.Action,
+		Reasons:        result.Reasons,
+		Source:         result.Source,
+	}
+	if err := h.moderationRepo.CreateFlag(flag); err != nil {
+		log.Printf("Error flagging message from user %s: %v", senderID, err)
+	}
+}
@@ -0,0 +1,189 @@
+package chat_socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// Channel the API instances LISTEN on
+	pubSubChannel = "chat_events"
+
+	// NOTIFY payloads must be shorter than 8000 bytes; larger events are
+	// stored in chat_events and only their ID is sent
+	maxNotifyPayload = 7000
+
+	// How long stored events are kept for instances to read them
+	storedEventTTL = time.Minute
+
+	// Interval between pings of the listener connection, to notice when it
+	// drops without a connection error
+	listenerPingPeriod = 90 * time.Second
+)
+
+// notification is the NOTIFY payload: the event itself, or the ID of the
+// chat_events row holding it
+type notification struct {
+	Event *Event `json:"event,omitempty"`
+	Ref   int64  `json:"ref,omitempty"`
+}
+
+// PostgresPubSub fans events out to every API instance connected to the same
+// database through LISTEN/NOTIFY. Events published while an instance's
+// listener is reconnecting are lost to it; its clients catch up from their
+// inboxes with a sync frame.
+type PostgresPubSub struct {
+	db       *sqlx.DB
+	listener *pq.Listener
+	events   chan Event
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPostgresPubSub starts listening for events on a dedicated connection
+// to databaseURL, and publishes through db.
+func NewPostgresPubSub(db *sqlx.DB, databaseURL string) (*PostgresPubSub, error) {
+	listener := pq.NewListener(databaseURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("Chat PubSub: ", err)
+		}
+	})
+	if err := listener.Listen(pubSubChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", pubSubChannel, err)
+	}
+
+	p := &PostgresPubSub{
+		db:       db,
+		listener: listener,
+		events:   make(chan Event, 256),
+		closed:   make(chan struct{}),
+	}
+	go p.listen()
+	return p, nil
+}
+
+func (p *PostgresPubSub) Publish(event Event) error {
+	select {
+	case <-p.closed:
+		return ErrPubSubClosed
+	default:
+	}
+
+	payload, err := json.Marshal(notification{Event: &event})
+	if err != nil {
+		return err
+	}
+
+	if len(payload) >= maxNotifyPayload {
+		if payload, err = p.store(event); err != nil {
+			return err
+		}
+	}
+
+	if _, err := p.db.Exec(`SELECT pg_notify($1, $2)`, pubSubChannel, string(payload)); err != nil {
+		return fmt.Errorf("failed to publish chat event: %w", err)
+	}
+	return nil
+}
+
+// store saves an event too large for NOTIFY and returns the payload
+// referring to it, clearing out events every instance has read by now
+func (p *PostgresPubSub) store(event Event) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `DELETE FROM chat_events WHERE created_at < NOW() - $1 * INTERVAL '1 second'`
+	if _, err := p.db.Exec(query, storedEventTTL.Seconds()); err != nil {
+		log.Println("Chat PubSub: failed to clean up stored events: ", err)
+	}
+
+	var id int64
+	query = `INSERT INTO chat_events (payload) VALUES ($1) RETURNING id`
+	if err := p.db.QueryRow(query, string(data)).Scan(&id); err != nil {
+		return nil, fmt.Errorf("failed to store chat event: %w", err)
+	}
+
+	return json.Marshal(notification{Ref: id})
+}
+
+func (p *PostgresPubSub) Events() <-chan Event {
+	return p.events
+}
+
+func (p *PostgresPubSub) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		err = p.listener.Close()
+	})
+	return err
+}
+
+// listen decodes notifications into events until Close is called
+func (p *PostgresPubSub) listen() {
+	ticker := time.NewTicker(listenerPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			if n == nil {
+				// Sent after reconnecting; anything published meanwhile is gone
+				log.Println("Chat PubSub: listener reconnected")
+				continue
+			}
+
+			event, err := p.decode(n.Extra)
+			if err != nil {
+				log.Println("Chat PubSub: ", err)
+				continue
+			}
+
+			select {
+			case p.events <- *event:
+			case <-p.closed:
+				return
+			}
+
+		case <-ticker.C:
+			go p.listener.Ping()
+
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+func (p *PostgresPubSub) decode(payload string) (*Event, error) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		return nil, fmt.Errorf("invalid chat event: %w", err)
+	}
+	if n.Event != nil {
+		return n.Event, nil
+	}
+
+	var data []byte
+	if err := p.db.QueryRow(`SELECT payload FROM chat_events WHERE id = $1`, n.Ref).Scan(&data); err != nil {
+		return nil, fmt.Errorf("failed to load chat event %d: %w", n.Ref, err)
+	}
+
+	event := &Event{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, fmt.Errorf("invalid chat event %d: %w", n.Ref, err)
+	}
+	return event, nil
+}
@@ -0,0 +1,64 @@
+package chat_socket
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ErrPubSubClosed is returned when publishing after the pub/sub was closed
+var ErrPubSubClosed = errors.New("pub/sub closed")
+
+// Event is a frame published to every hub instance for a set of users, each
+// of whom gets it under their own inbox sequence number. From is the ID of
+// the connection the frame came from, which gets it as a sent frame on
+// whichever instance it is connected to.
+type Event struct {
+	Message Message             `json:"message"`
+	Seqs    map[uuid.UUID]int64 `json:"seqs"`
+	From    string              `json:"from,omitempty"`
+}
+
+// PubSub fans hub events out to every instance of the API, including the one
+// that published them, so a message reaches its recipients wherever they
+// are connected.
+type PubSub interface {
+	Publish(event Event) error
+	// Events returns the channel published events arrive on
+	Events() <-chan Event
+	Close() error
+}
+
+// LocalPubSub delivers events within this process only. It is enough when a
+// single instance of the API runs.
+type LocalPubSub struct {
+	events    chan Event
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewLocalPubSub() *LocalPubSub {
+	return &LocalPubSub{
+		events: make(chan Event, 256),
+		closed: make(chan struct{}),
+	}
+}
+
+func (p *LocalPubSub) Publish(event Event) error {
+	select {
+	case p.events <- event:
+		return nil
+	case <-p.closed:
+		return ErrPubSubClosed
+	}
+}
+
+func (p *LocalPubSub) Events() <-chan Event {
+	return p.events
+}
+
+func (p *LocalPubSub) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
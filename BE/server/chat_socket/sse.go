@@ -0,0 +1,221 @@
+package chat_socket
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"tukarkultur/api/middleware"
+	"tukarkultur/api/models"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// HandleEventStream streams the user's chat frames as Server-Sent Events,
+// for networks that block WebSocket upgrades. Each event is named after the
+// frame's type and carries the frame as its data; message and sent frames
+// have their inbox seq as event ID. A reconnecting EventSource sends the
+// last one back in Last-Event-ID (or the client sets ?last_seq=), and gets
+// everything after it followed by a synced event. Without it only what never
+// reached any device is flushed, as on the WebSocket. Frames are sent with
+// POST /chat/frames.
+func (h *Hub) HandleEventStream(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	session, hasSession := middleware.CurrentSession(c)
+	if !ok || !hasSession {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var lastSeq int64
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_seq")
+	}
+	if value != "" {
+		seq, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seq < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid last event ID"})
+			return
+		}
+		lastSeq = seq
+	}
+
+	stream := &eventStream{w: c.Writer, controller: http.NewResponseController(c.Writer)}
+	client := &Client{
+		id:               uuid.NewString(),
+		hub:              h,
+		userID:           userID,
+		sessionToken:     session.Token,
+		sessionExpiresAt: session.ExpiresAt,
+		clientIP:         c.ClientIP(),
+		writeFrame: func(msg Message) error {
+			event := sse.Event{Event: msg.Type, Data: msg}
+			switch {
+			case (msg.Type == FrameMessage || msg.Type == FrameSent) && msg.Seq > 0:
+				event.Id = strconv.FormatInt(msg.Seq, 10)
+			case msg.Type == FrameSynced && msg.LastSeq != nil:
+				event.Id = strconv.FormatInt(*msg.LastSeq, 10)
+			}
+			return stream.write(func(w io.Writer) error { return sse.Encode(w, event) })
+		},
+		send: make(chan Message, sendBufferSize),
+	}
+	if !h.Register(client) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server shutting down"})
+		return
+	}
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // keep proxies from holding events back
+	c.Status(http.StatusOK)
+
+	h.presence.Connect(userID)
+	defer func() {
+		h.Unregister(client)
+		h.presence.Disconnect(userID)
+	}()
+	client.streamPump(c.Request.Context().Done(), stream, lastSeq)
+}
+
+// streamPump is the write pump of an event stream: it flushes the backlog
+// after lastSeq, then sends queued frames and keep-alive comments until the
+// client goes away, the hub drops the client or the session ends.
+func (c *Client) streamPump(done <-chan struct{}, stream *eventStream, lastSeq int64) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	// Live messages queue up in send meanwhile; clients dedupe on seq
+	if err := c.writeBacklog(lastSeq, lastSeq == 0); err != nil {
+		log.Println("Flush Inbox: ", err)
+		return
+	}
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				// Removed from the hub
+				return
+			}
+			if err := c.write(msg); err != nil {
+				log.Println("Write Event: ", err)
+				return
+			}
+
+		case <-ticker.C:
+			if reason := c.sessionEnded(); reason != "" {
+				c.writeFrame(Message{Type: FrameError, Error: reason})
+				return
+			}
+			// A comment, which EventSource ignores, keeps proxies from
+			// timing the stream out
+			err := stream.write(func(w io.Writer) error {
+				_, err := io.WriteString(w, ": ping\n\n")
+				return err
+			})
+			if err != nil {
+				return
+			}
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// eventStream is the response an event stream is written to
+type eventStream struct {
+	w          io.Writer
+	controller *http.ResponseController
+}
+
+// write writes to the stream within writeWait and flushes it
+func (s *eventStream) write(write func(w io.Writer) error) error {
+	if err := s.controller.SetWriteDeadline(time.Now().Add(writeWait)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if err := write(s.w); err != nil {
+		return err
+	}
+	return s.controller.Flush()
+}
+
+// HandleFrame takes a frame like those sent over the WebSocket, for clients
+// on the event stream. Chat messages are answered with their sent frame;
+// everything the frame causes, including the message itself, also goes out
+// to the user's connections as usual. Frames count against per-user rate
+// limits. A sync is done by reconnecting the event stream instead.
+func (h *Hub) HandleFrame(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxMessageSize)
+	var msg Message
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg.Type == FrameSync {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reconnect the event stream with Last-Event-ID to sync"})
+		return
+	}
+
+	if !h.allowPosted(userID, msg.Type, time.Now()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": errRateLimited.Error()})
+		return
+	}
+
+	// The frame wasn't sent on a connection, so every one of the user's
+	// connections gets the message rather than a sent frame
+	client := &Client{hub: h, userID: userID}
+	sent, err := h.handleFrame(client, msg)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidMessage) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process frame"})
+		return
+	}
+
+	if sent != nil {
+		sent.ClientID = msg.ClientID
+		c.JSON(http.StatusOK, sent)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Frame processed"})
+}
+
+// allowPosted applies the rate limits to a frame userID posted, dropping
+// the limiters of users who stopped posting
+func (h *Hub) allowPosted(userID uuid.UUID, frameType string, now time.Time) bool {
+	h.postLimitsMu.Lock()
+	defer h.postLimitsMu.Unlock()
+
+	if now.Sub(h.postLimitsPrunedAt) > maxStrikes*strikeInterval {
+		for id, limiter := range h.postLimits {
+			if limiter.idle(now) {
+				delete(h.postLimits, id)
+			}
+		}
+		h.postLimitsPrunedAt = now
+	}
+
+	limiter, ok := h.postLimits[userID]
+	if !ok {
+		limiter = newRateLimiter()
+		h.postLimits[userID] = limiter
+	}
+	allowed, _ := limiter.allow(frameType, now)
+	return allowed
+}
@@ -0,0 +1,118 @@
+package chat_socket
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+	"tukarkultur/api/models"
+
+	"github.com/google/uuid"
+)
+
+// translationTimeout is how long after a message its translations are still
+// pushed. Later ones are only cached for the history, since the receiver has
+// read the original by then.
+const translationTimeout = 20 * time.Second
+
+// defaultTranslationWorkers is how many translations run at once unless
+// CHAT_TRANSLATION_WORKERS says otherwise
+const defaultTranslationWorkers = 8
+
+func translationWorkers() int {
+	if workers, err := strconv.Atoi(os.Getenv("CHAT_TRANSLATION_WORKERS")); err == nil && workers > 0 {
+		return workers
+	}
+	return defaultTranslationWorkers
+}
+
+// translate translates the text of a stored message in the background for
+// every other participant of its conversation who turned translation on,
+// once per preferred language, and pushes each translation to them in a
+// translation frame as soon as it is done. Languages are translated
+// concurrently, so one slow translation doesn't hold back the others, and the
+// message itself goes out right away. At most CHAT_TRANSLATION_WORKERS
+// lookups and translations run at once; work beyond that is dropped rather
+// than queued, and those receivers see the original only. Translations that
+// come back unchanged, e.g. because the text was in that language already,
+// are neither cached nor pushed. Failures are logged and the message stays
+// untranslated, so chat keeps working when the AI service is unreachable.
+func (h *Hub) translate(message *models.Message) {
+	if h.translator == nil || message.MessageText == nil {
+		return
+	}
+
+	// The caller may reuse message once this returns
+	translated := *message
+	text := *message.MessageText
+	deadline := time.Now().Add(translationTimeout)
+	started := h.startTranslation(func() {
+		languages, err := h.conversationRepo.GetTranslationLanguages(translated.ConversationID)
+		if err != nil {
+			log.Printf("Error getting translation languages of conversation %s: %v", translated.ConversationID, err)
+			return
+		}
+		delete(languages, translated.SenderID)
+
+		receivers := make(map[string]map[uuid.UUID]int64)
+		for userID, language := range languages {
+			if receivers[language] == nil {
+				receivers[language] = make(map[uuid.UUID]int64)
+			}
+			receivers[language][userID] = 0
+		}
+		for language, userIDs := range receivers {
+			if !h.startTranslation(func() { h.pushTranslation(&translated, text, language, userIDs, deadline) }) {
+				log.Printf("Translation of message %s into %s dropped: too many translations running", translated.ID, language)
+			}
+		}
+	})
+	if !started {
+		log.Printf("Translation of message %s dropped: too many translations running", translated.ID)
+	}
+}
+
+// startTranslation runs work in the background if a translation slot is
+// free and reports whether it did
+func (h *Hub) startTranslation(work func()) bool {
+	select {
+	case h.translating <- struct{}{}:
+	default:
+		return false
+	}
+	go func() {
+		defer func() { <-h.translating }()
+		work()
+	}()
+	return true
+}
+
+// pushTranslation translates text, the text of message, into language,
+// caches it and sends it to userIDs unless deadline has passed
+func (h *Hub) pushTranslation(message *models.Message, text, language string, userIDs map[uuid.UUID]int64, deadline time.Time) {
+	translation, err := h.translator.Translate(text, language)
+	if err != nil {
+		log.Printf("Error translating message %s into %s: %v", message.ID, language, err)
+		return
+	}
+	if translation == text {
+		return
+	}
+
+	if err := h.messageRepo.SaveTranslation(message.ID, message.EditedAt, language, translation); err != nil {
+		log.Printf("Error caching translation of message %s: %v", message.ID, err)
+	}
+	if time.Now().After(deadline) {
+		log.Printf("Translation of message %s into %s came too late to push", message.ID, language)
+		return
+	}
+
+	h.Broadcast(Message{
+		Type:           FrameTranslation,
+		ID:             &message.ID,
+		ConversationID: &message.ConversationID,
+		EditedAt:       message.EditedAt,
+		Text:           text,
+		Translation:    &models.MessageTranslation{Language: language, Text: translation},
+	}, userIDs, nil)
+}
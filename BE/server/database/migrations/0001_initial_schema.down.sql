@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS interactions;
+DROP TABLE IF EXISTS meetups;
+DROP TABLE IF EXISTS friend_requests;
+DROP TABLE IF EXISTS friends;
+DROP TABLE IF EXISTS users;
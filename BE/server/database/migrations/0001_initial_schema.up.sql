@@ -1,8 +1,13 @@
 -- Streamlined Cultural Meetup App Database Schema
 -- Core tables only for location-based cultural exchange app
+--
+-- IF NOT EXISTS so databases created from the old schema.sql can adopt
+-- migrations without being recreated.
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto; -- gen_random_uuid() before PostgreSQL 13
 
 -- Users table - Core user information
-CREATE TABLE users (
+CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     username VARCHAR(50) UNIQUE NOT NULL,
     email VARCHAR(255) UNIQUE NOT NULL,
@@ -13,33 +18,47 @@ CREATE TABLE users (
     age INT,
     city VARCHAR(100),
     country VARCHAR(100),
-    
+
     interests TEXT[], -- ["food", "music", "history", "traditions"]
-    
+
     -- Location
     latitude DECIMAL(10, 8),
     longitude DECIMAL(11, 8),
     location_updated_at TIMESTAMP WITH TIME ZONE,
-    
+
     -- Stats
     total_interactions INT DEFAULT 0,
     average_rating DECIMAL(3,2) DEFAULT 0.00,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
--- Connections between users (simplified matching)
-CREATE TABLE friends (
+-- Connections between users (simplified matching), stored in both directions
+CREATE TABLE IF NOT EXISTS friends (
     user_id_1 UUID REFERENCES users(id) ON DELETE CASCADE,
     user_id_2 UUID REFERENCES users(id) ON DELETE CASCADE,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     PRIMARY KEY(user_id_1, user_id_2)
 );
 
+-- Pending and answered friend requests
+CREATE TABLE IF NOT EXISTS friend_requests (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    requester_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, accepted, rejected
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_friend_requests_recipient_id ON friend_requests(recipient_id, status);
+CREATE INDEX IF NOT EXISTS idx_friend_requests_requester_id ON friend_requests(requester_id);
+
 -- Meetups (actual real-world meetings)
-CREATE TABLE meetups (
+CREATE TABLE IF NOT EXISTS meetups (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     proposed_by UUID REFERENCES users(id) ON DELETE CASCADE,
+    proposed_to UUID REFERENCES users(id) ON DELETE CASCADE, -- null for open proposals
     location_name VARCHAR(255),
     location_address TEXT,
     meetup_time TIMESTAMP WITH TIME ZONE,
@@ -47,14 +66,17 @@ CREATE TABLE meetups (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Older databases were created before proposed_to existed
+ALTER TABLE meetups ADD COLUMN IF NOT EXISTS proposed_to UUID REFERENCES users(id) ON DELETE CASCADE;
+
 -- Post-meetup reviews (like Uber rating system)
-CREATE TABLE interactions (
+CREATE TABLE IF NOT EXISTS interactions (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     meetup_id UUID REFERENCES meetups(id) ON DELETE CASCADE,
     reviewer_id UUID REFERENCES users(id) ON DELETE CASCADE,
     reviewed_user_id UUID REFERENCES users(id) ON DELETE CASCADE,
     rating INT CHECK (rating >= 1 AND rating <= 5),
-    
+
     -- Post-meetup photo together
     meetup_photo_url TEXT, -- Cloudinary URL: https://res.cloudinary.com/your-cloud/image/upload/v1234567890/meetups/meetup_abc123.jpg
     meetup_photo_public_id VARCHAR(255), -- For managing the photo: meetups/meetup_abc123
@@ -64,9 +86,8 @@ CREATE TABLE interactions (
     UNIQUE(meetup_id, reviewer_id)
 );
 
-Belum
 -- Simple chat messages (just for coordinating meetups)
-CREATE TABLE messages (
+CREATE TABLE IF NOT EXISTS messages (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     sender_id UUID REFERENCES users(id) ON DELETE CASCADE,
     message_text TEXT, -- null if message_type is 'image'
@@ -75,4 +96,3 @@ CREATE TABLE messages (
     cloudinary_public_id VARCHAR(255), -- For managing/deleting images: chat/msg_xyz789
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
-
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
+    ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS suspension_reason TEXT;
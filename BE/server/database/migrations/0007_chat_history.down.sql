@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_messages_receiver_id;
+DROP INDEX IF EXISTS idx_messages_sender_id;
+DROP INDEX IF EXISTS idx_messages_conversation;
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS conversation_id,
+    DROP COLUMN IF EXISTS receiver_id;
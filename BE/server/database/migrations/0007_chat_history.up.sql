@@ -0,0 +1,9 @@
+-- Messages were only relayed in memory before, record who they were for
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS receiver_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS conversation_id UUID; -- derived from the sender/receiver pair
+
+-- History is paged newest first by (created_at, id)
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages(sender_id);
+CREATE INDEX IF NOT EXISTS idx_messages_receiver_id ON messages(receiver_id);
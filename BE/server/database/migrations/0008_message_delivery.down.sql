@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_deliveries;
+DROP TABLE IF EXISTS user_message_sequences;
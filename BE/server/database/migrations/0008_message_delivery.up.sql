@@ -0,0 +1,18 @@
+-- Last inbox sequence number handed out per user
+CREATE TABLE IF NOT EXISTS user_message_sequences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    last_seq BIGINT NOT NULL DEFAULT 0
+);
+
+-- Every message appears in the inbox of its sender and receiver with a
+-- per-user sequence number, so each device can ask for what it missed
+CREATE TABLE IF NOT EXISTS message_deliveries (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    delivered_at TIMESTAMP WITH TIME ZONE, -- null until written to one of the user's sockets
+    PRIMARY KEY (user_id, seq),
+    UNIQUE (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_deliveries_pending ON message_deliveries(user_id, seq) WHERE delivered_at IS NULL;
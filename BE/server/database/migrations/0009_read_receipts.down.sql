@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_settings;
+ALTER TABLE message_deliveries DROP COLUMN IF EXISTS read_at;
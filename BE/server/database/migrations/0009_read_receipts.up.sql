@@ -0,0 +1,8 @@
+ALTER TABLE message_deliveries ADD COLUMN IF NOT EXISTS read_at TIMESTAMP WITH TIME ZONE;
+
+-- Per-user preferences, a missing row means all defaults
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    read_receipts BOOLEAN NOT NULL DEFAULT TRUE, -- let senders see when their messages were read
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+ALTER TABLE user_settings DROP COLUMN IF EXISTS online_status;
+ALTER TABLE users DROP COLUMN IF EXISTS last_seen_at;
@@ -0,0 +1,5 @@
+-- When the user's last chat connection closed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE;
+
+-- Let friends see when the user is online
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS online_status BOOLEAN NOT NULL DEFAULT TRUE;
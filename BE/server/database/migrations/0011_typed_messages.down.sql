@@ -0,0 +1,5 @@
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS meetup_id,
+    DROP COLUMN IF EXISTS location_name,
+    DROP COLUMN IF EXISTS longitude,
+    DROP COLUMN IF EXISTS latitude;
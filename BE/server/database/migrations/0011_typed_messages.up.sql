@@ -0,0 +1,6 @@
+-- Payloads of location and meetup_proposal messages
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS latitude DECIMAL(10, 8),
+    ADD COLUMN IF NOT EXISTS longitude DECIMAL(11, 8),
+    ADD COLUMN IF NOT EXISTS location_name VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS meetup_id UUID REFERENCES meetups(id) ON DELETE SET NULL;
@@ -0,0 +1,9 @@
+-- Chat events too large for a NOTIFY payload, read by every API instance
+-- from the ID in the notification and cleared out after a minute
+CREATE TABLE IF NOT EXISTS chat_events (
+    id BIGSERIAL PRIMARY KEY,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_events_created_at ON chat_events(created_at);
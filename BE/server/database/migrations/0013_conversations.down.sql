@@ -0,0 +1,6 @@
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_conversation_id_fkey;
+-- Group messages can't be represented without conversations
+DELETE FROM messages WHERE receiver_id IS NULL AND conversation_id IS NOT NULL;
+DROP INDEX IF EXISTS idx_message_deliveries_unread;
+DROP TABLE IF EXISTS conversation_participants;
+DROP TABLE IF EXISTS conversations;
@@ -0,0 +1,48 @@
+-- Conversations were implied by the sender/receiver pair of their messages.
+-- Direct conversations keep the ID derived from the pair; group
+-- conversations (e.g. the attendees of a meetup) get a random one.
+CREATE TABLE IF NOT EXISTS conversations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    type VARCHAR(20) NOT NULL DEFAULT 'direct', -- direct, group
+    title VARCHAR(100), -- group conversations only
+    meetup_id UUID REFERENCES meetups(id) ON DELETE SET NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS conversation_participants (
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'member', -- owner, member
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_participants_user_id ON conversation_participants(user_id);
+
+-- Unread counters count the user's unread deliveries
+CREATE INDEX IF NOT EXISTS idx_message_deliveries_unread ON message_deliveries(user_id) WHERE read_at IS NULL;
+
+-- Existing one-to-one chats become direct conversations
+INSERT INTO conversations (id, type, created_at)
+SELECT conversation_id, 'direct', MIN(created_at)
+FROM messages
+WHERE conversation_id IS NOT NULL
+GROUP BY conversation_id
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO conversation_participants (conversation_id, user_id, joined_at)
+SELECT conversation_id, user_id, MIN(created_at)
+FROM (
+    SELECT conversation_id, sender_id AS user_id, created_at FROM messages WHERE conversation_id IS NOT NULL
+    UNION ALL
+    SELECT conversation_id, receiver_id, created_at FROM messages WHERE conversation_id IS NOT NULL
+) pairs
+WHERE user_id IS NOT NULL
+GROUP BY conversation_id, user_id
+ON CONFLICT (conversation_id, user_id) DO NOTHING;
+
+-- Every message belongs to a conversation; group messages have no receiver_id
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_conversation_id_fkey;
+ALTER TABLE messages ADD CONSTRAINT messages_conversation_id_fkey
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_messages_search_vector;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS chat_cjk_terms(TEXT, BOOLEAN);
+DROP TEXT SEARCH CONFIGURATION IF EXISTS public.indonesian;
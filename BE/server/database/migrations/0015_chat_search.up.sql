@@ -0,0 +1,37 @@
+-- Full-text search over chat messages. Users write in Indonesian, English
+-- and Japanese, so each message is indexed under all three.
+
+-- Older Postgres releases ship no Indonesian stemmer; fall back to
+-- unstemmed words there
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'indonesian') THEN
+        CREATE TEXT SEARCH CONFIGURATION public.indonesian (COPY = pg_catalog.simple);
+    END IF;
+END
+$$;
+
+-- Japanese doesn't separate words with spaces, so runs of kana and kanji are
+-- indexed as overlapping bigrams plus single characters. Queries use the
+-- bigrams, or the character itself when it is a single one. The terms skip
+-- the text search parser, which can't split Japanese.
+CREATE OR REPLACE FUNCTION chat_cjk_terms(input TEXT, is_query BOOLEAN) RETURNS TEXT[] AS $$
+    SELECT COALESCE(array_agg(t.term), '{}')
+    FROM regexp_matches(COALESCE(input, ''), '[\u3040-\u30ff\u3400-\u4dbf\u4e00-\u9fff\uf900-\ufaff\uff66-\uff9f]+', 'g') AS r(run),
+    LATERAL (
+        SELECT substr(r.run[1], i, 2) AS term
+        FROM generate_series(1, char_length(r.run[1]) - 1) AS i
+        UNION ALL
+        SELECT substr(r.run[1], i, 1)
+        FROM generate_series(1, char_length(r.run[1])) AS i
+        WHERE NOT is_query OR char_length(r.run[1]) = 1
+    ) AS t
+$$ LANGUAGE SQL IMMUTABLE;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    to_tsvector('english', COALESCE(message_text, '')) ||
+    to_tsvector('indonesian', COALESCE(message_text, '')) ||
+    array_to_tsvector(chat_cjk_terms(message_text, FALSE))
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector);
@@ -0,0 +1,19 @@
+-- Chat messages the content filter flagged or blocked, for moderator review.
+-- Blocked messages were never sent, so only their text is kept.
+CREATE TABLE IF NOT EXISTS flagged_messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_text TEXT NOT NULL,
+    action VARCHAR(10) NOT NULL, -- flag, block
+    reasons TEXT[] NOT NULL DEFAULT '{}',
+    source VARCHAR(20) NOT NULL, -- wordlist, ai
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, upheld, dismissed
+    reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_flagged_messages_status ON flagged_messages(status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_flagged_messages_sender_id ON flagged_messages(sender_id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS message_translations;
+
+ALTER TABLE conversation_participants DROP COLUMN IF EXISTS translate;
+
+ALTER TABLE user_settings DROP COLUMN IF EXISTS preferred_language;
@@ -0,0 +1,14 @@
+-- Live translation. Users pick the language they read chat in, and turn
+-- translation on per conversation; incoming messages are then translated
+-- into that language once per (message, language).
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS preferred_language VARCHAR(10);
+
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS translate BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS message_translations (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    language VARCHAR(10) NOT NULL,
+    translated_text TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, language)
+);
@@ -0,0 +1,13 @@
+-- Open chat connections per API instance, so every instance sees who is
+-- online. Instances refresh heartbeat_at while running; rows of an instance
+-- that stopped without closing its connections go stale and are dropped.
+CREATE TABLE IF NOT EXISTS presence_connections (
+    instance_id UUID NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    connections INT NOT NULL,
+    heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (instance_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_presence_connections_user_id ON presence_connections(user_id);
+CREATE INDEX IF NOT EXISTS idx_presence_connections_heartbeat_at ON presence_connections(heartbeat_at);
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"tukarkultur/api/middleware"
+	"tukarkultur/api/models"
+	"tukarkultur/api/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AdminHandler serves the moderation endpoints under /admin. Every route is
+// restricted to moderators and above; changing roles requires an admin.
+type AdminHandler struct {
+	userRepo        *repository.UserRepository
+	authRepo        *repository.AuthRepository
+	meetupRepo      *repository.MeetupRepository
+	interactionRepo *repository.InteractionRepository
+	messageRepo     *repository.MessageRepository
+	moderationRepo  *repository.ModerationRepository
+}
+
+func NewAdminHandler(
+	userRepo *repository.UserRepository,
+	authRepo *repository.AuthRepository,
+	meetupRepo *repository.MeetupRepository,
+	interactionRepo *repository.InteractionRepository,
+	messageRepo *repository.MessageRepository,
+	moderationRepo *repository.ModerationRepository,
+) *AdminHandler {
+	return &AdminHandler{
+		userRepo:        userRepo,
+		authRepo:        authRepo,
+		meetupRepo:      meetupRepo,
+		interactionRepo: interactionRepo,
+		messageRepo:     messageRepo,
+		moderationRepo:  moderationRepo,
+	}
+}
+
+// GET /admin/users
+// Optional filters: ?role=moderator, ?suspended=true
+func (h *AdminHandler) GetUsers(c *gin.Context) {
+	role := c.Query("role")
+	if role != "" && !models.IsValidRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be: user, moderator, or admin"})
+		return
+	}
+	suspended := c.Query("suspended")
+
+	users, err := h.userRepo.GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	filtered := make([]*models.User, 0, len(users))
+	for _, user := range users {
+		if role != "" && user.Role != role {
+			continue
+		}
+		if suspended != "" && user.IsSuspended() != (suspended == "true") {
+			continue
+		}
+		user.PasswordHash = ""
+		filtered = append(filtered, user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": filtered,
+		"count": len(filtered),
+	})
+}
+
+// PUT /admin/users/:id/suspend
+// Suspending signs the user out of every session.
+func (h *AdminHandler) SuspendUser(c *gin.Context) {
+	target, ok := h.loadManageableUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.SuspendUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userRepo.Suspend(target.ID, req.Reason); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to suspend user"})
+		return
+	}
+
+	revoked, err := h.authRepo.DeleteUserSessions(target.ID, 0)
+	if err != nil {
+		log.Printf("Error revoking sessions of suspended user %s: %v", target.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "User suspended successfully",
+		"revoked_sessions": revoked,
+	})
+}
+
+// PUT /admin/users/:id/unsuspend
+func (h *AdminHandler) UnsuspendUser(c *gin.Context) {
+	target, ok := h.loadManageableUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.userRepo.Unsuspend(target.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsuspend user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unsuspended successfully"})
+}
+
+// PUT /admin/users/:id/role
+func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
+	target, ok := h.loadManageableUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userRepo.UpdateRole(target.ID, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role updated successfully",
+		"role":    req.Role,
+	})
+}
+
+// DELETE /admin/meetups/:id
+func (h *AdminHandler) DeleteMeetup(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid meetup ID"})
+		return
+	}
+
+	if _, err := h.meetupRepo.GetByID(id); err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Meetup not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve meetup"})
+		return
+	}
+
+	if err := h.meetupRepo.Delete(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete meetup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Meetup removed successfully"})
+}
+
+// DELETE /admin/interactions/:id
+func (h *AdminHandler) DeleteInteraction(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interaction ID"})
+		return
+	}
+
+	if _, err := h.interactionRepo.GetByID(id); err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Interaction not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve interaction"})
+		return
+	}
+
+	if err := h.interactionRepo.Delete(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete interaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Interaction removed successfully"})
+}
+
+// GET /admin/messages/:id/history
+// A chat message with every text it had before it was edited or deleted for
+// everyone, oldest first.
+func (h *AdminHandler) GetMessageHistory(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	messages, err := h.messageRepo.GetByIDs([]uuid.UUID{id}, uuid.Nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve message"})
+		return
+	}
+	if len(messages) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	edits, err := h.messageRepo.GetEdits(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve message history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": messages[0],
+		"edits":   edits,
+	})
+}
+
+// GET /admin/flagged-messages?status=pending&limit=50&offset=0
+// Chat messages the content filter flagged or blocked, oldest first. Status
+// is pending (the default), upheld or dismissed.
+func (h *AdminHandler) GetFlaggedMessages(c *gin.Context) {
+	status := c.DefaultQuery("status", models.FlagStatusPending)
+	switch status {
+	case models.FlagStatusPending, models.FlagStatusUpheld, models.FlagStatusDismissed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	limit = min(limit, 100)
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	flags, err := h.moderationRepo.GetFlags(status, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve flagged messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"flagged_messages": flags})
+}
+
+// PUT /admin/flagged-messages/:id
+// Records the review: upheld when the message broke the rules (suspend the
+// sender through /admin/users/:id/suspend if need be), or dismissed.
+func (h *AdminHandler) ReviewFlaggedMessage(c *gin.Context) {
+	reviewerID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flagged message ID"})
+		return
+	}
+
+	var req models.ReviewFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	flag, err := h.moderationRepo.Review(id, req.Status, reviewerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Flagged message not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review flagged message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"flagged_message": flag})
+}
+
+// loadManageableUser loads the :id user and checks the caller strictly
+// outranks them, so moderators can't act on other moderators, admins can't
+// act on other admins and nobody can act on themselves. It writes the error response and returns false on failure.
+func (h *AdminHandler) loadManageableUser(c *gin.Context) (*models.User, bool) {
+	caller, ok := middleware.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+	if id == caller.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot manage your own account"})
+		return nil, false
+	}
+
+	target, err := h.userRepo.GetByID(id)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return nil, false
+	}
+
+	if target.HasRole(caller.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot manage a user with the same or higher role"})
+		return nil, false
+	}
+
+	return target, true
+}
@@ -2,22 +2,98 @@ package handlers
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
-	"strings"
+	"os"
+	"strconv"
+	"time"
+	"tukarkultur/api/middleware"
 	"tukarkultur/api/models"
 	"tukarkultur/api/repository"
+	"tukarkultur/api/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
-	authRepo *repository.AuthRepository
+	authRepo         authStore
+	loginGuard       *loginGuard
+	mailer           services.Mailer
+	totpService      *services.TOTPService
+	accessTokenTTL   time.Duration
+	refreshTokenTTL  time.Duration
+	passwordResetTTL time.Duration
+	passwordResetURL string
+
+	emailVerifier                *EmailVerifier
+	emailVerificationResendAfter time.Duration
+
+	loginChallengeTTL time.Duration
 }
 
-func NewAuthHandler(authRepo *repository.AuthRepository) *AuthHandler {
-	return &AuthHandler{authRepo: authRepo}
+// authStore is the part of repository.AuthRepository the auth handler uses
+type authStore interface {
+	CreateUserWithPassword(user *models.User, passwordHash string) error
+	GetUserByEmail(email string) (*models.User, error)
+	GetUserByID(id uuid.UUID) (*models.User, error)
+	GetPasswordHash(email string) (string, error)
+	GetPasswordHashByUserID(userID uuid.UUID) (string, error)
+	UpdatePasswordHash(userID uuid.UUID, passwordHash string) error
+
+	CreateSession(session *models.AuthSession) error
+	GetUserSessions(userID uuid.UUID) ([]*models.AuthSession, error)
+	DeleteSession(token string) (bool, error)
+	DeleteUserSession(userID uuid.UUID, sessionID uint) (bool, error)
+	DeleteUserSessions(userID uuid.UUID, keepSessionID uint) (int64, error)
+
+	CreateRefreshToken(token *models.RefreshToken) error
+	GetRefreshTokenByHash(tokenHash string) (*models.RefreshToken, error)
+	RotateRefreshToken(currentID uuid.UUID, next *models.RefreshToken, session *models.AuthSession) error
+	RevokeRefreshFamily(familyID uuid.UUID) error
+
+	CreatePasswordResetToken(token *models.PasswordResetToken) error
+	ResetPassword(tokenHash, passwordHash string) (uuid.UUID, error)
+	GetLastEmailVerificationSentAt(userID uuid.UUID) (*time.Time, error)
+	VerifyEmail(tokenHash string) (uuid.UUID, error)
+
+	GetTOTP(userID uuid.UUID) (*models.UserTOTP, error)
+	IsTOTPEnabled(userID uuid.UUID) (bool, error)
+	SaveTOTPSecret(userID uuid.UUID, secret string) (bool, error)
+	EnableTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(userID uuid.UUID) error
+	UseTOTPStep(userID uuid.UUID, step int64) (bool, error)
+	UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error)
+	CountUnusedRecoveryCodes(userID uuid.UUID) (int, error)
+
+	CreateLoginChallenge(challenge *models.LoginChallenge) error
+	GetLoginChallengeByHash(tokenHash string) (*models.LoginChallenge, error)
+	FailLoginChallenge(id uuid.UUID, maxAttempts int) (int, error)
+	ConsumeLoginChallenge(id uuid.UUID) (bool, error)
+}
+
+func NewAuthHandler(authRepo *repository.AuthRepository, loginAttemptRepo *repository.LoginAttemptRepository, mailer services.Mailer, totpService *services.TOTPService, emailVerifier *EmailVerifier) *AuthHandler {
+	return &AuthHandler{
+		authRepo:         authRepo,
+		loginGuard:       newLoginGuard(loginAttemptRepo),
+		mailer:           mailer,
+		totpService:      totpService,
+		accessTokenTTL:   durationFromEnv("ACCESS_TOKEN_TTL", 24*time.Hour),
+		refreshTokenTTL:  durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		passwordResetTTL: durationFromEnv("PASSWORD_RESET_TTL", time.Hour),
+		passwordResetURL: os.Getenv("PASSWORD_RESET_URL"),
+
+		emailVerifier:                emailVerifier,
+		emailVerificationResendAfter: durationFromEnv("EMAIL_VERIFICATION_RESEND_INTERVAL", time.Minute),
+
+		loginChallengeTTL: durationFromEnv("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute),
+	}
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -52,20 +128,25 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate simple token
-	token := h.generateToken()
+	// A failed email doesn't fail registration, the user can ask for a resend
+	if err := h.emailVerifier.send(user, false); err != nil {
+		log.Printf("Error sending verification email to user %s: %v", user.ID, err)
+	}
 
-	// Create session
-	err = h.authRepo.CreateSession(user.ID, token)
+	// Create session with a new refresh token family
+	tokens, err := h.issueTokens(c, user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"token":   token,
-		"user":    user,
-		"message": "Registration successful",
+		"token":              tokens.Token,
+		"expires_at":         tokens.ExpiresAt,
+		"refresh_token":      tokens.RefreshToken,
+		"refresh_expires_at": tokens.RefreshExpiresAt,
+		"user":               user,
+		"message":            "Registration successful",
 	})
 }
 
@@ -76,9 +157,20 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	ip := c.ClientIP()
+
+	// Refuse outright while the account or IP is backing off; otherwise the
+	// attempt counts as failed until the password checks out
+	if lockedUntil := h.loginGuard.begin(req.Email, ip); lockedUntil != nil {
+		h.loginGuard.fail(req.Email, nil, ip, loginReasonLocked)
+		respondLocked(c, *lockedUntil)
+		return
+	}
+
 	// Get user
 	user, err := h.authRepo.GetUserByEmail(req.Email)
 	if err != nil {
+		h.loginGuard.fail(req.Email, nil, ip, loginReasonUnknownEmail)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
@@ -93,37 +185,881 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	// Compare password with bcrypt
 	err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password))
 	if err != nil {
+		h.loginGuard.fail(req.Email, &user.ID, ip, loginReasonInvalidPassword)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// Generate token
-	token := h.generateToken()
+	if user.IsSuspended() {
+		respondSuspended(c, user)
+		return
+	}
+
+	// Accounts with two-factor enabled get a challenge instead of a session.
+	// The attempt stays counted as failed until the code checks out, so
+	// fresh challenges can't be used to guess codes past the lockout.
+	twoFactorEnabled, err := h.authRepo.IsTOTPEnabled(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor status"})
+		return
+	}
+	if twoFactorEnabled {
+		h.startLoginChallenge(c, user)
+		return
+	}
+
+	h.loginGuard.succeed(req.Email, user.ID, ip)
 
-	// Create session
-	err = h.authRepo.CreateSession(user.ID, token)
+	// Create session with a new refresh token family
+	tokens, err := h.issueTokens(c, user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"token":   token,
-		"user":    user,
-		"message": "Login successful",
+		"token":              tokens.Token,
+		"expires_at":         tokens.ExpiresAt,
+		"refresh_token":      tokens.RefreshToken,
+		"refresh_expires_at": tokens.RefreshExpiresAt,
+		"user":               user,
+		"message":            "Login successful",
 	})
 }
 
 func (h *AuthHandler) Logout(c *gin.Context) {
-	token := c.GetHeader("Authorization")
-	token = strings.TrimPrefix(token, "Bearer ")
+	token := middleware.BearerToken(c)
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authorization token is required"})
+		return
+	}
+
+	// Also revokes the refresh family so the refresh token can't revive the session
+	existed, err := h.authRepo.DeleteSession(token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+	if !existed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
 
-	h.authRepo.DeleteSession(token)
 	c.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
 }
 
-func (h *AuthHandler) generateToken() string {
+// GET /auth/login-attempts
+// Recent failed logins against the caller's account.
+func (h *AuthHandler) GetFailedLoginAttempts(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	attempts, err := h.loginGuard.repo.GetFailedAttemptsForUser(userID, 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve login attempts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"login_attempts": attempts})
+}
+
+// GET /auth/sessions
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	current, ok := middleware.CurrentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := h.authRepo.GetUserSessions(current.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sessions"})
+		return
+	}
+
+	response := make([]models.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, models.SessionResponse{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			CreatedAt:  session.CreatedAt,
+			Current:    session.ID == current.ID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": response})
+}
+
+// DELETE /auth/sessions/:id
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	current, ok := middleware.CurrentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	existed, err := h.authRepo.DeleteUserSession(current.UserID, uint(sessionID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if !existed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// DELETE /auth/sessions
+// Signs out every device except the one making the request.
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	current, ok := middleware.CurrentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	revoked, err := h.authRepo.DeleteUserSessions(current.UserID, current.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Other sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
+
+// POST /auth/refresh
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	current, err := h.authRepo.GetRefreshTokenByHash(hashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate refresh token"})
+		return
+	}
+
+	if current.RevokedAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has been revoked"})
+		return
+	}
+
+	// A rotated token being presented again means it leaked: kill the whole family
+	if current.RotatedAt != nil {
+		h.revokeReusedFamily(current)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, please log in again"})
+		return
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has expired"})
+		return
+	}
+
+	tokens, next, session := h.newTokens(c, current.UserID, current.FamilyID)
+	if err := h.authRepo.RotateRefreshToken(current.ID, next, session); err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			h.revokeReusedFamily(current)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, please log in again"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":              tokens.Token,
+		"expires_at":         tokens.ExpiresAt,
+		"refresh_token":      tokens.RefreshToken,
+		"refresh_expires_at": tokens.RefreshExpiresAt,
+		"message":            "Token refreshed",
+	})
+}
+
+// POST /auth/password/change
+// Other sessions are signed out; the session making the change stays valid.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	session, ok := middleware.CurrentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	passwordHash, err := h.authRepo.GetPasswordHashByUserID(session.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify password"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := h.authRepo.UpdatePasswordHash(session.UserID, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	revoked, err := h.authRepo.DeleteUserSessions(session.UserID, session.ID)
+	if err != nil {
+		log.Printf("Error revoking sessions after password change for user %s: %v", session.UserID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Password changed successfully",
+		"revoked_sessions": revoked,
+	})
+}
+
+// POST /auth/password/forgot
+// Always answers the same way so the endpoint can't be used to probe for accounts.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"message": "If an account with that email exists, a password reset link has been sent"}
+
+	user, err := h.authRepo.GetUserByEmail(req.Email)
+	if err != nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	token := generateToken()
+	resetToken := &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(h.passwordResetTTL),
+	}
+	if err := h.authRepo.CreatePasswordResetToken(resetToken); err != nil {
+		log.Printf("Error creating password reset token for user %s: %v", user.ID, err)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if err := h.mailer.Send(h.passwordResetEmail(user, token)); err != nil {
+		log.Printf("Error sending password reset email to user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// POST /auth/password/reset
+// Every session is signed out once the password is reset.
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	userID, err := h.authRepo.ResetPassword(hashToken(req.Token), string(hashedPassword))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	if _, err := h.authRepo.DeleteUserSessions(userID, 0); err != nil {
+		log.Printf("Error revoking sessions after password reset for user %s: %v", userID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successful, please log in again"})
+}
+
+// GET /auth/verify?token=
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token parameter is required"})
+		return
+	}
+
+	userID, err := h.authRepo.VerifyEmail(hashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email verified successfully",
+		"user_id": userID,
+	})
+}
+
+// POST /auth/verify/resend
+func (h *AuthHandler) ResendVerificationEmail(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	lastSentAt, err := h.authRepo.GetLastEmailVerificationSentAt(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check verification status"})
+		return
+	}
+	if lastSentAt != nil {
+		if wait := time.Until(lastSentAt.Add(h.emailVerificationResendAfter)); wait > 0 {
+			retryAfter := int64(wait.Seconds()) + 1
+			c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Verification email was sent recently, please wait before trying again",
+				"retry_after": retryAfter,
+			})
+			return
+		}
+	}
+
+	if err := h.emailVerifier.send(user, false); err != nil {
+		log.Printf("Error sending verification email to user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+func (h *AuthHandler) passwordResetEmail(user *models.User, token string) *services.MailMessage {
+	var body string
+	if h.passwordResetURL != "" {
+		body = fmt.Sprintf("Hi %s,\n\nOpen this link to reset your TukarKultur password:\n%s?token=%s\n\n", user.FullName, h.passwordResetURL, token)
+	} else {
+		body = fmt.Sprintf("Hi %s,\n\nUse this code to reset your TukarKultur password:\n%s\n\n", user.FullName, token)
+	}
+	body += fmt.Sprintf("It expires in %s. If you didn't ask for a reset, you can ignore this email.\n", h.passwordResetTTL)
+
+	return &services.MailMessage{
+		To:      user.Email,
+		Subject: "Reset your TukarKultur password",
+		Body:    body,
+	}
+}
+
+// maxLoginChallengeAttempts wrong codes burn a login challenge
+const maxLoginChallengeAttempts = 5
+
+// recoveryCodeCount is how many recovery codes are issued on enrollment
+const recoveryCodeCount = 10
+
+func (h *AuthHandler) startLoginChallenge(c *gin.Context, user *models.User) {
+	token := generateToken()
+	challenge := &models.LoginChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(h.loginChallengeTTL),
+	}
+	if err := h.authRepo.CreateLoginChallenge(challenge); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"two_factor_required": true,
+		"challenge_token":     token,
+		"expires_at":          challenge.ExpiresAt,
+		"message":             "Two-factor authentication required",
+	})
+}
+
+// POST /auth/2fa/verify
+// Completes a two-factor login with a TOTP code or a recovery code. Codes
+// count against the login throttle like passwords.
+func (h *AuthHandler) VerifyTwoFactorLogin(c *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code is required"})
+		return
+	}
+
+	challenge, err := h.authRepo.GetLoginChallengeByHash(hashToken(req.ChallengeToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, please log in again"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate challenge"})
+		return
+	}
+
+	user, err := h.authRepo.GetUserByID(challenge.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return
+	}
+
+	// Codes are throttled with the password, so guessing them runs into the
+	// same account lockout
+	ip := c.ClientIP()
+	if lockedUntil := h.loginGuard.begin(user.Email, ip); lockedUntil != nil {
+		h.loginGuard.fail(user.Email, &user.ID, ip, loginReasonLocked)
+		respondLocked(c, *lockedUntil)
+		return
+	}
+
+	valid, err := h.verifySecondFactor(challenge.UserID, req.Code, req.RecoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		h.loginGuard.fail(user.Email, &user.ID, ip, loginReasonInvalidTwoFactor)
+		attempts, err := h.authRepo.FailLoginChallenge(challenge.ID, maxLoginChallengeAttempts)
+		if err != nil {
+			log.Printf("Error recording failed two-factor attempt for challenge %s: %v", challenge.ID, err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":              "Invalid two-factor code",
+			"attempts_remaining": max(maxLoginChallengeAttempts-attempts, 0),
+		})
+		return
+	}
+
+	consumed, err := h.authRepo.ConsumeLoginChallenge(challenge.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete login"})
+		return
+	}
+	if !consumed {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, please log in again"})
+		return
+	}
+
+	if user.IsSuspended() {
+		respondSuspended(c, user)
+		return
+	}
+
+	h.loginGuard.succeed(user.Email, user.ID, ip)
+
+	tokens, err := h.issueTokens(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":              tokens.Token,
+		"expires_at":         tokens.ExpiresAt,
+		"refresh_token":      tokens.RefreshToken,
+		"refresh_expires_at": tokens.RefreshExpiresAt,
+		"user":               user,
+		"message":            "Login successful",
+	})
+}
+
+// GET /auth/2fa
+func (h *AuthHandler) GetTwoFactorStatus(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	enabled, err := h.authRepo.IsTOTPEnabled(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor status"})
+		return
+	}
+
+	remaining := 0
+	if enabled {
+		remaining, err = h.authRepo.CountUnusedRecoveryCodes(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check recovery codes"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// POST /auth/2fa/enroll
+// Returns a new secret to add to an authenticator app; two-factor isn't
+// enforced until the enrollment is confirmed.
+func (h *AuthHandler) EnrollTwoFactor(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	secret, err := h.totpService.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	saved, err := h.authRepo.SaveTOTPSecret(user.ID, secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+	if !saved {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": h.totpService.ProvisioningURI(user.Email, secret),
+		"message":     "Scan the code with your authenticator app, then confirm with a generated code",
+	})
+}
+
+// POST /auth/2fa/confirm
+// Recovery codes are only ever returned here, once.
+func (h *AuthHandler) ConfirmTwoFactor(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	totp, err := h.authRepo.GetTOTP(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No two-factor enrollment in progress"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load enrollment"})
+		return
+	}
+	if totp.EnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	step, valid := h.totpService.Validate(totp.Secret, req.Code, time.Now())
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	recoveryCodes, err := h.totpService.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes = append(hashes, hashToken(h.totpService.NormalizeRecoveryCode(code)))
+	}
+
+	if err := h.authRepo.EnableTOTP(userID, step, hashes); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// POST /auth/2fa/disable
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	passwordHash, err := h.authRepo.GetPasswordHashByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify password"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	enabled, err := h.authRepo.IsTOTPEnabled(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor status"})
+		return
+	}
+	if !enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	// Accept either kind of code, whichever the user has at hand
+	valid, err := h.verifySecondFactor(userID, req.Code, "")
+	if err == nil && !valid {
+		valid, err = h.verifySecondFactor(userID, "", req.Code)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	if err := h.authRepo.DisableTOTP(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// verifySecondFactor checks a TOTP code, or failing that a recovery code,
+// for a user with confirmed two-factor. Accepted codes are used up.
+func (h *AuthHandler) verifySecondFactor(userID uuid.UUID, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		totp, err := h.authRepo.GetTOTP(userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return false, nil
+			}
+			return false, err
+		}
+		if totp.EnabledAt == nil {
+			return false, nil
+		}
+
+		step, valid := h.totpService.Validate(totp.Secret, code, time.Now())
+		if !valid {
+			return false, nil
+		}
+		return h.authRepo.UseTOTPStep(userID, step)
+	}
+
+	if recoveryCode != "" {
+		return h.authRepo.UseRecoveryCode(userID, hashToken(h.totpService.NormalizeRecoveryCode(recoveryCode)))
+	}
+
+	return false, nil
+}
+
+func (h *AuthHandler) revokeReusedFamily(token *models.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %s, revoking family %s", token.UserID, token.FamilyID)
+	if err := h.authRepo.RevokeRefreshFamily(token.FamilyID); err != nil {
+		log.Printf("Error revoking refresh token family %s: %v", token.FamilyID, err)
+	}
+}
+
+// issueTokens starts a new refresh token family for the user and returns the
+// access and refresh tokens for it.
+func (h *AuthHandler) issueTokens(c *gin.Context, userID uuid.UUID) (*models.TokenPair, error) {
+	tokens, refreshToken, session := h.newTokens(c, userID, uuid.New())
+
+	if err := h.authRepo.CreateRefreshToken(refreshToken); err != nil {
+		return nil, err
+	}
+	if err := h.authRepo.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// newTokens generates an access session and refresh token in the given family
+// without persisting them. The session records the requesting device.
+func (h *AuthHandler) newTokens(c *gin.Context, userID, familyID uuid.UUID) (*models.TokenPair, *models.RefreshToken, *models.AuthSession) {
+	now := time.Now()
+	tokens := &models.TokenPair{
+		Token:            generateToken(),
+		ExpiresAt:        now.Add(h.accessTokenTTL),
+		RefreshToken:     generateToken(),
+		RefreshExpiresAt: now.Add(h.refreshTokenTTL),
+	}
+
+	refreshToken := &models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(tokens.RefreshToken),
+		ExpiresAt: tokens.RefreshExpiresAt,
+	}
+	session := &models.AuthSession{
+		UserID:     userID,
+		Token:      tokens.Token,
+		FamilyID:   &familyID,
+		DeviceName: optionalString(c.GetHeader("X-Device-Name")),
+		UserAgent:  optionalString(c.Request.UserAgent()),
+		IPAddress:  optionalString(c.ClientIP()),
+		ExpiresAt:  tokens.ExpiresAt,
+	}
+	return tokens, refreshToken, session
+}
+
+// respondLocked rejects a login attempt while the account or IP is locked out
+func respondLocked(c *gin.Context, lockedUntil time.Time) {
+	retryAfter := int64(time.Until(lockedUntil).Seconds()) + 1
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts, please try again later",
+		"retry_after": retryAfter,
+	})
+}
+
+// respondSuspended rejects a login for a suspended account, telling the user why
+func respondSuspended(c *gin.Context, user *models.User) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":  "Account suspended",
+		"code":   "account_suspended",
+		"reason": user.SuspensionReason,
+	})
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// GET /auth/me
+func (h *AuthHandler) Me(c *gin.Context) {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	session, ok := middleware.CurrentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"data":       user,
+		"expires_at": session.ExpiresAt,
+	})
+}
+
+// GET /auth/validate
+// Invalid or expired tokens are rejected by the auth middleware with 401.
+func (h *AuthHandler) Validate(c *gin.Context) {
+	session, ok := middleware.CurrentSession(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"valid": false, "error": "Unauthorized"})
+		return
+	}
+
+	remaining := time.Until(session.ExpiresAt)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":      true,
+		"user_id":    session.UserID,
+		"expires_at": session.ExpiresAt,
+		"expires_in": int64(remaining.Seconds()),
+	})
+}
+
+// generateToken returns a random 256-bit token, hex encoded
+func generateToken() string {
 	bytes := make([]byte, 32)
 	rand.Read(bytes)
 	return hex.EncodeToString(bytes)
 }
+
+// hashToken returns the hex SHA-256 of a token for storage and lookup
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// durationFromEnv parses a Go duration (e.g. "15m", "720h") from the
+// environment, falling back to def when unset or invalid.
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using default %s", key, value, def)
+		return def
+	}
+	return d
+}
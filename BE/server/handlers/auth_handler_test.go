@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"tukarkultur/api/models"
+	"tukarkultur/api/repository"
+	"tukarkultur/api/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeAuthStore keeps the auth tables in memory. Methods a test doesn't
+// expect to be called fall through to the nil authStore and panic.
+type fakeAuthStore struct {
+	authStore
+
+	mu             sync.Mutex
+	users          map[string]*models.User // by email
+	passwordHashes map[string]string       // by email
+	twoFactor      map[uuid.UUID]bool
+	recoveryCodes  map[string]bool // unused code hashes
+	challenges     map[string]*models.LoginChallenge
+	sessions       []*models.AuthSession
+	refreshTokens  map[string]*models.RefreshToken // by hash
+}
+
+func newFakeAuthStore() *fakeAuthStore {
+	return &fakeAuthStore{
+		users:          make(map[string]*models.User),
+		passwordHashes: make(map[string]string),
+		twoFactor:      make(map[uuid.UUID]bool),
+		recoveryCodes:  make(map[string]bool),
+		challenges:     make(map[string]*models.LoginChallenge),
+		refreshTokens:  make(map[string]*models.RefreshToken),
+	}
+}
+
+// addUser stores a user who logs in with password
+func (s *fakeAuthStore) addUser(t *testing.T, email, password string) *models.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: email, Username: "someone"}
+	s.users[email] = user
+	s.passwordHashes[email] = string(hash)
+	return user
+}
+
+func (s *fakeAuthStore) GetUserByEmail(email string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[email]; ok {
+		copy := *user
+		return &copy, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeAuthStore) GetUserByID(id uuid.UUID) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.ID == id {
+			copy := *user
+			return &copy, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeAuthStore) GetPasswordHash(email string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hash, ok := s.passwordHashes[email]; ok {
+		return hash, nil
+	}
+	return "", sql.ErrNoRows
+}
+
+func (s *fakeAuthStore) IsTOTPEnabled(userID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.twoFactor[userID], nil
+}
+
+func (s *fakeAuthStore) GetTOTP(userID uuid.UUID) (*models.UserTOTP, error) {
+	now := time.Now()
+	return &models.UserTOTP{UserID: userID, Secret: "JBSWY3DPEHPK3PXP", EnabledAt: &now}, nil
+}
+
+func (s *fakeAuthStore) UseTOTPStep(uuid.UUID, int64) (bool, error) {
+	return true, nil
+}
+
+func (s *fakeAuthStore) UseRecoveryCode(_ uuid.UUID, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.recoveryCodes[codeHash] {
+		return false, nil
+	}
+	delete(s.recoveryCodes, codeHash)
+	return true, nil
+}
+
+func (s *fakeAuthStore) CreateLoginChallenge(challenge *models.LoginChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenge.ID = uuid.New()
+	challenge.CreatedAt = time.Now()
+	s.challenges[challenge.TokenHash] = challenge
+	return nil
+}
+
+func (s *fakeAuthStore) GetLoginChallengeByHash(tokenHash string) (*models.LoginChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenge, ok := s.challenges[tokenHash]
+	if !ok || challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	copy := *challenge
+	return &copy, nil
+}
+
+func (s *fakeAuthStore) challenge(id uuid.UUID) *models.LoginChallenge {
+	for _, challenge := range s.challenges {
+		if challenge.ID == id {
+			return challenge
+		}
+	}
+	return nil
+}
+
+func (s *fakeAuthStore) FailLoginChallenge(id uuid.UUID, maxAttempts int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenge := s.challenge(id)
+	challenge.Attempts++
+	if challenge.Attempts >= maxAttempts {
+		now := time.Now()
+		challenge.UsedAt = &now
+	}
+	return challenge.Attempts, nil
+}
+
+func (s *fakeAuthStore) ConsumeLoginChallenge(id uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenge := s.challenge(id)
+	if challenge.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	challenge.UsedAt = &now
+	return true, nil
+}
+
+func (s *fakeAuthStore) CreateRefreshToken(token *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token.ID = uuid.New()
+	token.CreatedAt = time.Now()
+	s.refreshTokens[token.TokenHash] = token
+	return nil
+}
+
+func (s *fakeAuthStore) GetRefreshTokenByHash(tokenHash string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copy := *token
+	return &copy, nil
+}
+
+func (s *fakeAuthStore) RotateRefreshToken(currentID uuid.UUID, next *models.RefreshToken, session *models.AuthSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range s.refreshTokens {
+		if token.ID != currentID {
+			continue
+		}
+		if token.RotatedAt != nil || token.RevokedAt != nil {
+			return repository.ErrRefreshTokenReused
+		}
+		now := time.Now()
+		token.RotatedAt = &now
+	}
+
+	s.deleteFamilySessions(next.FamilyID)
+	next.ID = uuid.New()
+	next.CreatedAt = time.Now()
+	s.refreshTokens[next.TokenHash] = next
+	s.sessions = append(s.sessions, session)
+	return nil
+}
+
+func (s *fakeAuthStore) RevokeRefreshFamily(familyID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, token := range s.refreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	s.deleteFamilySessions(familyID)
+	return nil
+}
+
+func (s *fakeAuthStore) deleteFamilySessions(familyID uuid.UUID) {
+	kept := s.sessions[:0]
+	for _, session := range s.sessions {
+		if session.FamilyID == nil || *session.FamilyID != familyID {
+			kept = append(kept, session)
+		}
+	}
+	s.sessions = kept
+}
+
+func (s *fakeAuthStore) CreateSession(session *models.AuthSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = append(s.sessions, session)
+	return nil
+}
+
+func newTestAuthHandler(store authStore, throttles *fakeThrottleStore) *AuthHandler {
+	return &AuthHandler{
+		authRepo:          store,
+		loginGuard:        newTestLoginGuard(throttles),
+		totpService:       services.NewTOTPService(),
+		accessTokenTTL:    time.Hour,
+		refreshTokenTTL:   24 * time.Hour,
+		loginChallengeTTL: 5 * time.Minute,
+	}
+}
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// postJSON calls handler with body as a JSON request from testIP
+func postJSON(t *testing.T, handler gin.HandlerFunc, body any) (int, map[string]any) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.RemoteAddr = testIP + ":40000"
+	handler(c)
+
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response %q isn't JSON: %v", w.Body.String(), err)
+	}
+	return w.Code, response
+}
+
+const testPassword = "correct horse"
+
+func TestLoginResetsStreakOnSuccess(t *testing.T) {
+	store, throttles := newFakeAuthStore(), newFakeThrottleStore()
+	h := newTestAuthHandler(store, throttles)
+	store.addUser(t, testEmail, testPassword)
+
+	for i := 0; i < 2; i++ {
+		throttles.waitOut(testEmail, testIP)
+		if code, _ := postJSON(t, h.Login, models.AuthRequest{Email: testEmail, Password: "wrong password"}); code != http.StatusUnauthorized {
+			t.Fatalf("wrong password got %d, want %d", code, http.StatusUnauthorized)
+		}
+	}
+
+	throttles.waitOut(testEmail, testIP)
+	code, response := postJSON(t, h.Login, models.AuthRequest{Email: testEmail, Password: testPassword})
+	if code != http.StatusOK || response["token"] == nil {
+		t.Fatalf("login got %d %v, want a session", code, response)
+	}
+	if failures := throttles.throttle(accountThrottleKey(testEmail)).failures; failures != 0 {
+		t.Fatalf("account failures = %d after logging in, want 0", failures)
+	}
+}
+
+func TestTwoFactorLoginCountsAgainstLockout(t *testing.T) {
+	store, throttles := newFakeAuthStore(), newFakeThrottleStore()
+	h := newTestAuthHandler(store, throttles)
+	user := store.addUser(t, testEmail, testPassword)
+	store.twoFactor[user.ID] = true
+
+	// waitBackoff waits out the short backoffs between attempts, but not a
+	// lockout
+	waitBackoff := func() {
+		throttles.mu.Lock()
+		defer throttles.mu.Unlock()
+		for _, throttle := range throttles.throttles {
+			if wait := throttle.lockedUntil.Sub(throttles.now); wait > 0 && wait < h.loginGuard.lockoutDuration {
+				throttles.now = throttle.lockedUntil.Add(time.Second)
+			}
+		}
+	}
+
+	// Someone with the password asks for fresh challenges and guesses one
+	// code on each; the guesses and the unfinished logins add up to a lockout
+	for i := 0; ; i++ {
+		if i == h.loginGuard.maxAccountFailures {
+			t.Fatal("still not locked out after guessing a code on every challenge")
+		}
+
+		waitBackoff()
+		code, response := postJSON(t, h.Login, models.AuthRequest{Email: testEmail, Password: testPassword})
+		if code == http.StatusTooManyRequests {
+			break
+		}
+		if code != http.StatusOK || response["challenge_token"] == nil {
+			t.Fatalf("login got %d %v, want a challenge", code, response)
+		}
+		if failures := throttles.throttle(accountThrottleKey(testEmail)).failures; failures != 2*i+1 {
+			t.Fatalf("account failures = %d with a challenge pending, want the streak kept at %d", failures, 2*i+1)
+		}
+
+		waitBackoff()
+		code, _ = postJSON(t, h.VerifyTwoFactorLogin, models.TwoFactorLoginRequest{
+			ChallengeToken: response["challenge_token"].(string),
+			Code:           "abcdef",
+		})
+		if code == http.StatusTooManyRequests {
+			break
+		}
+		if code != http.StatusUnauthorized {
+			t.Fatalf("wrong code got %d, want %d", code, http.StatusUnauthorized)
+		}
+	}
+
+	account := throttles.throttle(accountThrottleKey(testEmail))
+	if lockout := account.lockedUntil.Sub(account.lastFailureAt); lockout < h.loginGuard.lockoutDuration {
+		t.Fatalf("account locked for %v, want at least %v", lockout, h.loginGuard.lockoutDuration)
+	}
+	if len(store.sessions) != 0 {
+		t.Fatalf("%d sessions created, want none", len(store.sessions))
+	}
+}
+
+func TestTwoFactorLoginLockedOut(t *testing.T) {
+	store, throttles := newFakeAuthStore(), newFakeThrottleStore()
+	h := newTestAuthHandler(store, throttles)
+	user := store.addUser(t, testEmail, testPassword)
+	store.twoFactor[user.ID] = true
+	store.recoveryCodes[hashToken("k3m9px7q2a")] = true
+
+	throttles.waitOut(testEmail, testIP)
+	_, response := postJSON(t, h.Login, models.AuthRequest{Email: testEmail, Password: testPassword})
+	challengeToken := response["challenge_token"].(string)
+
+	// Even the right code is refused while the account is locked
+	throttles.mu.Lock()
+	throttles.throttles[accountThrottleKey(testEmail)].lockedUntil = throttles.now.Add(time.Hour)
+	throttles.mu.Unlock()
+	code, _ := postJSON(t, h.VerifyTwoFactorLogin, models.TwoFactorLoginRequest{ChallengeToken: challengeToken, RecoveryCode: "k3m9p-x7q2a"})
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("code while locked got %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	throttles.waitOut(testEmail, testIP)
+	code, response = postJSON(t, h.VerifyTwoFactorLogin, models.TwoFactorLoginRequest{ChallengeToken: challengeToken, RecoveryCode: "k3m9p-x7q2a"})
+	if code != http.StatusOK || response["token"] == nil {
+		t.Fatalf("code after the lock got %d %v, want a session", code, response)
+	}
+	if failures := throttles.throttle(accountThrottleKey(testEmail)).failures; failures != 0 {
+		t.Fatalf("account failures = %d after the second factor passed, want 0", failures)
+	}
+}
+
+// loginForRefreshToken logs the test user in and returns their refresh token
+func loginForRefreshToken(t *testing.T, h *AuthHandler) string {
+	t.Helper()
+	code, response := postJSON(t, h.Login, models.AuthRequest{Email: testEmail, Password: testPassword})
+	if code != http.StatusOK {
+		t.Fatalf("login got %d %v, want a session", code, response)
+	}
+	return response["refresh_token"].(string)
+}
+
+func TestRefreshDetectsReuse(t *testing.T) {
+	store, throttles := newFakeAuthStore(), newFakeThrottleStore()
+	h := newTestAuthHandler(store, throttles)
+	store.addUser(t, testEmail, testPassword)
+	first := loginForRefreshToken(t, h)
+
+	code, response := postJSON(t, h.Refresh, models.RefreshRequest{RefreshToken: first})
+	if code != http.StatusOK || response["refresh_token"] == nil {
+		t.Fatalf("refresh got %d %v, want new tokens", code, response)
+	}
+	second := response["refresh_token"].(string)
+
+	// The rotated token coming back means it leaked, so the whole family goes
+	code, response = postJSON(t, h.Refresh, models.RefreshRequest{RefreshToken: first})
+	if code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token got %d %v, want %d", code, response, http.StatusUnauthorized)
+	}
+	if code, response = postJSON(t, h.Refresh, models.RefreshRequest{RefreshToken: second}); code != http.StatusUnauthorized {
+		t.Fatalf("refresh token issued before the reuse got %d %v, want %d", code, response, http.StatusUnauthorized)
+	}
+	if len(store.sessions) != 0 {
+		t.Fatalf("%d sessions left, want the family's sessions ended", len(store.sessions))
+	}
+}
+
+func TestRefreshRaceRevokesFamily(t *testing.T) {
+	store, throttles := newFakeAuthStore(), newFakeThrottleStore()
+	h := newTestAuthHandler(store, throttles)
+	store.addUser(t, testEmail, testPassword)
+	token := loginForRefreshToken(t, h)
+
+	// Only one exchange of a token can win; the loser looks like a replay
+	const attempts = 5
+	codes := make(chan int, attempts)
+	tokens := make(chan string, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code, response := postJSON(t, h.Refresh, models.RefreshRequest{RefreshToken: token})
+			codes <- code
+			if next, ok := response["refresh_token"].(string); ok {
+				tokens <- next
+			}
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	close(tokens)
+
+	succeeded := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			succeeded++
+		}
+	}
+	if succeeded > 1 {
+		t.Fatalf("%d of %d parallel refreshes succeeded, want at most 1", succeeded, attempts)
+	}
+	for next := range tokens {
+		if code, _ := postJSON(t, h.Refresh, models.RefreshRequest{RefreshToken: next}); code != http.StatusUnauthorized {
+			t.Fatalf("token from a raced refresh got %d, want it revoked with its family", code)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"tukarkultur/api/middleware"
+	"tukarkultur/api/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// isOwnerOrRole reports whether the caller is ownerID or holds at least role
+func isOwnerOrRole(c *gin.Context, ownerID uuid.UUID, role string) bool {
+	caller, ok := middleware.CurrentUser(c)
+	if !ok {
+		return false
+	}
+	return caller.ID == ownerID || caller.HasRole(role)
+}
+
+// redactUser hides the private fields of user unless the caller is that user
+// or a moderator
+func redactUser(c *gin.Context, user *models.User) {
+	user.PasswordHash = ""
+	if isOwnerOrRole(c, user.ID, models.RoleModerator) {
+		return
+	}
+	user.Email = ""
+	user.EmailVerifiedAt = nil
+	user.SuspendedAt = nil
+	user.SuspensionReason = nil
+}
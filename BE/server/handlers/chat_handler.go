@@ -0,0 +1,658 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"tukarkultur/api/middleware"
+	"tukarkultur/api/models"
+	"tukarkultur/api/repository"
+	"tukarkultur/api/services"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMessagePageSize = 50
+	defaultSearchPageSize  = 20
+	maxMessagePageSize     = 100
+
+	maxChatImageSize = 10 << 20 // 10 MB
+)
+
+// chatImageTypes are the image formats accepted in chat, by sniffed content type
+var chatImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+type ChatHandler struct {
+	messageRepo       *repository.MessageRepository
+	conversationRepo  *repository.ConversationRepository
+	meetupRepo        *repository.MeetupRepository
+	cloudinaryService *services.CloudinaryService
+}
+
+func NewChatHandler(
+	messageRepo *repository.MessageRepository,
+	conversationRepo *repository.ConversationRepository,
+	meetupRepo *repository.MeetupRepository,
+	cloudinaryService *services.CloudinaryService,
+) *ChatHandler {
+	return &ChatHandler{
+		messageRepo:       messageRepo,
+		conversationRepo:  conversationRepo,
+		meetupRepo:        meetupRepo,
+		cloudinaryService: cloudinaryService,
+	}
+}
+
+// GET /chat/conversations
+// The user's direct and group conversations, most recently active first,
+// with their last message and unread count.
+func (h *ChatHandler) GetConversations(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	conversations, err := h.conversationRepo.GetForUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve conversations"})
+		return
+	}
+
+	var messageIDs []uuid.UUID
+	for _, conversation := range conversations {
+		if conversation.LastMessageID != nil {
+			messageIDs = append(messageIDs, *conversation.LastMessageID)
+		}
+	}
+
+	messages, err := h.messageRepo.GetByIDs(messageIDs, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve conversations"})
+		return
+	}
+
+	lastMessages := make(map[uuid.UUID]*models.Message, len(messages))
+	for _, message := range messages {
+		lastMessages[message.ID] = message
+	}
+	for _, conversation := range conversations {
+		if conversation.LastMessageID != nil {
+			conversation.LastMessage = lastMessages[*conversation.LastMessageID]
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversations": conversations})
+}
+
+// POST /chat/conversations
+// Creates a group conversation owned by the user. With a meetup_id the
+// meetup's participants are added along with participant_ids.
+func (h *ChatHandler) CreateConversation(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.CreateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	participantIDs := req.ParticipantIDs
+	if req.MeetupID != nil {
+		meetup, err := h.meetupRepo.GetByID(*req.MeetupID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Meetup not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve meetup"})
+			return
+		}
+		if meetup.ProposedBy != userID && (meetup.ProposedTo == nil || *meetup.ProposedTo != userID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not part of this meetup"})
+			return
+		}
+
+		participantIDs = append(participantIDs, meetup.ProposedBy)
+		if meetup.ProposedTo != nil {
+			participantIDs = append(participantIDs, *meetup.ProposedTo)
+		}
+	}
+
+	participantIDs = withoutUser(participantIDs, userID)
+	if len(participantIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A group needs at least one other participant"})
+		return
+	}
+	if len(participantIDs)+1 > models.MaxGroupParticipants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A group can have at most %d participants", models.MaxGroupParticipants)})
+		return
+	}
+
+	conversation := &models.Conversation{
+		Title:     req.Title,
+		MeetupID:  req.MeetupID,
+		CreatedBy: &userID,
+	}
+	if err := h.conversationRepo.CreateGroup(conversation, participantIDs); err != nil {
+		if respondUnknownUsers(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"conversation": conversation})
+}
+
+// GET /chat/conversations/:id
+func (h *ChatHandler) GetConversation(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	conversation, ok := h.loadConversation(c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// PUT /chat/conversations/:id
+// Renames a group conversation. Only its owner can.
+func (h *ChatHandler) UpdateConversation(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.UpdateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, ok := h.loadGroupAsOwner(c, userID)
+	if !ok {
+		return
+	}
+
+	if err := h.conversationRepo.UpdateTitle(conversation.ID, req.Title); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update conversation"})
+		return
+	}
+	conversation.Title = req.Title
+
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// POST /chat/conversations/:id/participants
+// Adds users to a group conversation. Only its owner can.
+func (h *ChatHandler) AddParticipants(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.AddParticipantsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, ok := h.loadGroupAsOwner(c, userID)
+	if !ok {
+		return
+	}
+
+	var newIDs []uuid.UUID
+	for _, id := range req.UserIDs {
+		if !conversation.IsParticipant(id) && !slices.Contains(newIDs, id) {
+			newIDs = append(newIDs, id)
+		}
+	}
+	if len(conversation.Participants)+len(newIDs) > models.MaxGroupParticipants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A group can have at most %d participants", models.MaxGroupParticipants)})
+		return
+	}
+
+	added, err := h.conversationRepo.AddParticipants(conversation.ID, newIDs)
+	if err != nil {
+		if respondUnknownUsers(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add participants"})
+		return
+	}
+
+	participants, err := h.conversationRepo.GetParticipants(conversation.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve participants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"added":        added,
+		"participants": participants,
+	})
+}
+
+// DELETE /chat/conversations/:id/participants/:user_id
+// The owner removes a member from a group, or a member removes themselves
+// to leave it. The owner can only leave once they are the last one in the
+// group, or after handing it over with PUT /chat/conversations/:id/owner.
+func (h *ChatHandler) RemoveParticipant(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	memberID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	conversation, ok := h.loadConversation(c, userID)
+	if !ok {
+		return
+	}
+	if conversation.Type != models.ConversationTypeGroup {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only group conversations have members to remove"})
+		return
+	}
+	if memberID != userID && conversation.Participant(userID).Role != models.ParticipantRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can remove other members"})
+		return
+	}
+
+	if err := h.conversationRepo.RemoveParticipant(conversation.ID, memberID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this conversation"})
+			return
+		}
+		if errors.Is(err, repository.ErrOwnerMustTransfer) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Hand the group over to another member before leaving"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove participant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Participant removed"})
+}
+
+// PUT /chat/conversations/:id/owner
+// Hands a group over to another member. Only its owner can, and stays on as
+// a member.
+func (h *ChatHandler) TransferOwnership(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, ok := h.loadGroupAsOwner(c, userID)
+	if !ok {
+		return
+	}
+	if req.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You already own this conversation"})
+		return
+	}
+
+	if err := h.conversationRepo.TransferOwnership(conversation.ID, userID, req.UserID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this conversation"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+		return
+	}
+
+	participants, err := h.conversationRepo.GetParticipants(conversation.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve participants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"participants": participants})
+}
+
+// PUT /chat/conversations/:id/translation
+// Turns translation of incoming messages into the user's preferred
+// language, see PUT /users/settings, on or off for this conversation.
+func (h *ChatHandler) UpdateTranslation(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.UpdateTranslationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, ok := h.loadConversation(c, userID)
+	if !ok {
+		return
+	}
+
+	if err := h.conversationRepo.SetTranslate(conversation.ID, userID, *req.Enabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update translation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation_id": conversation.ID, "translate": *req.Enabled})
+}
+
+// loadConversation loads the conversation in the :id param and checks userID
+// takes part in it, writing the error response if not
+func (h *ChatHandler) loadConversation(c *gin.Context, userID uuid.UUID) (*models.Conversation, bool) {
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return nil, false
+	}
+
+	conversation, err := h.conversationRepo.GetByID(conversationID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve conversation"})
+		return nil, false
+	}
+
+	// Outsiders can't tell a conversation exists
+	if !conversation.IsParticipant(userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return nil, false
+	}
+	return conversation, true
+}
+
+// loadGroupAsOwner is loadConversation for changes only a group's owner can make
+func (h *ChatHandler) loadGroupAsOwner(c *gin.Context, userID uuid.UUID) (*models.Conversation, bool) {
+	conversation, ok := h.loadConversation(c, userID)
+	if !ok {
+		return nil, false
+	}
+	if conversation.Type != models.ConversationTypeGroup {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only group conversations can be changed"})
+		return nil, false
+	}
+	if conversation.Participant(userID).Role != models.ParticipantRoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can change this conversation"})
+		return nil, false
+	}
+	return conversation, true
+}
+
+// respondUnknownUsers answers 400 with the IDs of the users that don't exist
+// if err is a *repository.UnknownUsersError, and reports whether it did
+func respondUnknownUsers(c *gin.Context, err error) bool {
+	var unknown *repository.UnknownUsersError
+	if !errors.As(err, &unknown) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":    "Some users don't exist",
+		"user_ids": unknown.UserIDs,
+	})
+	return true
+}
+
+// withoutUser dedupes ids and drops userID from them
+func withoutUser(ids []uuid.UUID, userID uuid.UUID) []uuid.UUID {
+	var result []uuid.UUID
+	for _, id := range ids {
+		if id != userID && !slices.Contains(result, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// GET /chat/conversations/:id/messages?limit=50&before=<cursor>
+// Messages come newest first; pass next_cursor as before to load older ones.
+// For a one-to-one chat the ID is the conversation_id on its messages, or
+// GET /chat/conversations/with/:user_id resolves it for a user. Group members
+// see the messages sent while they were in the group.
+func (h *ChatHandler) GetMessages(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	limit := defaultMessagePageSize
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(limit, maxMessagePageSize)
+	}
+
+	var before *models.MessageCursor
+	if value := c.Query("before"); value != "" {
+		before, err = models.DecodeMessageCursor(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	// Fetch one extra row to know whether there is an older page
+	messages, err := h.messageRepo.GetConversationMessages(conversationID, userID, before, limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
+		return
+	}
+
+	var nextCursor *string
+	if len(messages) > limit {
+		messages = messages[:limit]
+		last := messages[len(messages)-1]
+		cursor := models.MessageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+		nextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"messages":    messages,
+		"next_cursor": nextCursor,
+		"has_more":    nextCursor != nil,
+	})
+}
+
+// GET /chat/search?q=...&conversation_id=...&limit=20&before=<cursor>
+// Full-text search over the messages the user can see, newest first, in
+// English, Indonesian or Japanese. q takes web search syntax: "quoted
+// phrases", or and -excluded words. Each result has a snippet with the
+// matches wrapped in <mark> tags; pass next_cursor as before for more.
+func (h *ChatHandler) SearchMessages(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+	if utf8.RuneCountInString(query) > models.MaxSearchQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Search query must be at most %d characters", models.MaxSearchQueryLength)})
+		return
+	}
+
+	var conversationID *uuid.UUID
+	if value := c.Query("conversation_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+			return
+		}
+		conversationID = &id
+	}
+
+	limit := defaultSearchPageSize
+	if value := c.Query("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(limit, maxMessagePageSize)
+	}
+
+	var before *models.MessageCursor
+	if value := c.Query("before"); value != "" {
+		var err error
+		before, err = models.DecodeMessageCursor(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	// Fetch one extra row to know whether there is another page
+	results, err := h.messageRepo.Search(userID, query, conversationID, before, limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+		return
+	}
+
+	var nextCursor *string
+	if len(results) > limit {
+		results = results[:limit]
+		last := results[len(results)-1].Message
+		cursor := models.MessageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+		nextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":     results,
+		"next_cursor": nextCursor,
+		"has_more":    nextCursor != nil,
+	})
+}
+
+// GET /chat/conversations/with/:user_id
+func (h *ChatHandler) GetDirectConversation(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	peerID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversation_id": models.DirectConversationID(userID, peerID),
+		"peer_id":         peerID,
+	})
+}
+
+// POST /chat/images
+// Multipart form with an "image" file: JPEG, PNG, GIF or WebP up to 10 MB.
+// Send the returned image_url and image_public_id in an image message.
+func (h *ChatHandler) UploadImage(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxChatImageSize+1<<20)
+
+	file, header, err := c.Request.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No image uploaded, or it is larger than 10 MB"})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxChatImageSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image must be at most 10 MB"})
+		return
+	}
+
+	// Trust the file's content, not its name or the client's content type
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+		return
+	}
+	if !chatImageTypes[http.DetectContentType(head[:n])] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Image must be a JPEG, PNG, GIF or WebP file"})
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read image"})
+		return
+	}
+
+	result, err := h.cloudinaryService.UploadImage(file, models.ChatImageFolder(userID))
+	if err != nil || result.SecureURL == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload image"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"image_url":       result.SecureURL,
+		"image_public_id": result.PublicID,
+	})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+	"tukarkultur/api/models"
+	"tukarkultur/api/repository"
+	"tukarkultur/api/services"
+)
+
+// EmailVerifier issues email verification tokens and mails them, on
+// registration and whenever a user changes their email
+type EmailVerifier struct {
+	authRepo *repository.AuthRepository
+	mailer   services.Mailer
+
+	ttl time.Duration
+	url string
+}
+
+// NewEmailVerifier mails links to EMAIL_VERIFICATION_URL, which is
+// required: building the link from the request would let a forged Host
+// header send the token to another site.
+func NewEmailVerifier(authRepo *repository.AuthRepository, mailer services.Mailer) (*EmailVerifier, error) {
+	verifyURL := os.Getenv("EMAIL_VERIFICATION_URL")
+	if verifyURL == "" {
+		return nil, errors.New("EMAIL_VERIFICATION_URL is required")
+	}
+	if parsed, err := url.Parse(verifyURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("EMAIL_VERIFICATION_URL %q is not an absolute http(s) URL", verifyURL)
+	}
+
+	return &EmailVerifier{
+		authRepo: authRepo,
+		mailer:   mailer,
+		ttl:      durationFromEnv("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		url:      verifyURL,
+	}, nil
+}
+
+// send issues a new verification token for user's current email, which
+// replaces any unused one, and emails it. changed tells a new address from
+// the one the user registered with.
+func (v *EmailVerifier) send(user *models.User, changed bool) error {
+	token := generateToken()
+	verificationToken := &models.EmailVerificationToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(v.ttl),
+	}
+	if err := v.authRepo.CreateEmailVerificationToken(verificationToken); err != nil {
+		return err
+	}
+
+	intro := "Welcome to TukarKultur! Confirm your email address"
+	if changed {
+		intro = "Confirm your new TukarKultur email address"
+	}
+	return v.mailer.Send(&services.MailMessage{
+		To:      user.Email,
+		Subject: "Verify your TukarKultur email",
+		Body: fmt.Sprintf("Hi %s,\n\n%s by opening this link:\n%s?token=%s\n\nThe link expires in %s.\n",
+			user.FullName, intro, v.url, token, v.ttl),
+	})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"tukarkultur/api/middleware"
 	"tukarkultur/api/models"
 	"tukarkultur/api/repository"
 
@@ -22,15 +23,9 @@ func NewFriendHandler(friendRepo *repository.FriendRepository, userRepo *reposit
 }
 
 func (h *FriendHandler) GetAllFriends(c *gin.Context) {
-	userIDStr := c.Query("user_id")
-	if userIDStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id parameter is required"})
-		return
-	}
-
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
@@ -42,12 +37,18 @@ func (h *FriendHandler) GetAllFriends(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"friends": friends})
 }
 
+// CreateFriend adds a friendship directly. It is for admins only: users
+// become friends by accepting a friend request.
 func (h *FriendHandler) CreateFriend(c *gin.Context) {
 	var req models.CreateFriendRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.UserID1 == req.UserID2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Users cannot befriend themselves"})
+		return
+	}
 
 	friend := &models.Friend{
 		UserID1: req.UserID1,
@@ -63,6 +64,12 @@ func (h *FriendHandler) CreateFriend(c *gin.Context) {
 }
 
 func (h *FriendHandler) DeleteFriend(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
 	userID1Str := c.Query("user_id_1")
 	userID2Str := c.Query("user_id_2")
 
@@ -78,6 +85,12 @@ func (h *FriendHandler) DeleteFriend(c *gin.Context) {
 		return
 	}
 
+	// Users can only delete friendships they are part of
+	if userID1 != userID && userID2 != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to delete this friendship"})
+		return
+	}
+
 	if err := h.friendRepo.Delete(userID1, userID2); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete friendship"})
 		return
@@ -88,8 +101,13 @@ func (h *FriendHandler) DeleteFriend(c *gin.Context) {
 
 // Simplified friend request methods
 func (h *FriendHandler) SendFriendRequest(c *gin.Context) {
+	requesterID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
 	var req struct {
-		RequesterID uuid.UUID `json:"requester_id" binding:"required"`
 		RecipientID uuid.UUID `json:"recipient_id" binding:"required"`
 	}
 
@@ -106,13 +124,13 @@ func (h *FriendHandler) SendFriendRequest(c *gin.Context) {
 	}
 
 	// Check if users are the same
-	if req.RequesterID == req.RecipientID {
+	if requesterID == req.RecipientID {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot send friend request to yourself"})
 		return
 	}
 
 	// Check if already friends
-	areFriends, err := h.friendRepo.AreFriends(req.RequesterID, req.RecipientID)
+	areFriends, err := h.friendRepo.AreFriends(requesterID, req.RecipientID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check friendship status"})
 		return
@@ -123,7 +141,7 @@ func (h *FriendHandler) SendFriendRequest(c *gin.Context) {
 	}
 
 	// Check if friend request already exists
-	existingRequest, err := h.friendRepo.GetFriendRequestByUsers(req.RequesterID, req.RecipientID)
+	existingRequest, err := h.friendRepo.GetFriendRequestByUsers(requesterID, req.RecipientID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing requests"})
 		return
@@ -136,7 +154,7 @@ func (h *FriendHandler) SendFriendRequest(c *gin.Context) {
 	// Create new friend request
 	friendRequest := &models.FriendRequest{
 		ID:          uuid.New(),
-		RequesterID: req.RequesterID,
+		RequesterID: requesterID,
 		RecipientID: req.RecipientID,
 		Status:      models.FriendRequestStatusPending,
 	}
@@ -150,6 +168,12 @@ func (h *FriendHandler) SendFriendRequest(c *gin.Context) {
 }
 
 func (h *FriendHandler) RespondToFriendRequest(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
@@ -158,8 +182,7 @@ func (h *FriendHandler) RespondToFriendRequest(c *gin.Context) {
 	}
 
 	var req struct {
-		UserID uuid.UUID `json:"user_id" binding:"required"`
-		Status string    `json:"status" binding:"required,oneof=accepted rejected"`
+		Status string `json:"status" binding:"required,oneof=accepted rejected"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -175,7 +198,7 @@ func (h *FriendHandler) RespondToFriendRequest(c *gin.Context) {
 	}
 
 	// Check if user is the recipient
-	if friendRequest.RecipientID != req.UserID {
+	if friendRequest.RecipientID != userID {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to respond to this request"})
 		return
 	}
@@ -211,15 +234,9 @@ func (h *FriendHandler) RespondToFriendRequest(c *gin.Context) {
 }
 
 func (h *FriendHandler) GetReceivedFriendRequests(c *gin.Context) {
-	userIDStr := c.Query("user_id")
-	if userIDStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id parameter is required"})
-		return
-	}
-
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
@@ -233,15 +250,9 @@ func (h *FriendHandler) GetReceivedFriendRequests(c *gin.Context) {
 }
 
 func (h *FriendHandler) GetSentFriendRequests(c *gin.Context) {
-	userIDStr := c.Query("user_id")
-	if userIDStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id parameter is required"})
-		return
-	}
-
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"tukarkultur/api/middleware"
 	"tukarkultur/api/models"
 	"tukarkultur/api/repository"
 	"tukarkultur/api/services"
@@ -131,15 +132,21 @@ func (h *InteractionHandler) GetUserRatingStats(c *gin.Context) {
 }
 
 func (h *InteractionHandler) CreateInteraction(c *gin.Context) {
+	// The reviewer is always the authenticated user
+	reviewerID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
 	// Parse form data
 	meetupIDStr := c.PostForm("meetup_id")
-	reviewerIDStr := c.PostForm("reviewer_id")
 	reviewedUserIDStr := c.PostForm("reviewed_user_id")
 	ratingStr := c.PostForm("rating")
 	reviewText := c.PostForm("review_text")
 
 	// Validate required fields
-	if meetupIDStr == "" || reviewerIDStr == "" || reviewedUserIDStr == "" || ratingStr == "" {
+	if meetupIDStr == "" || reviewedUserIDStr == "" || ratingStr == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
 		return
 	}
@@ -151,12 +158,6 @@ func (h *InteractionHandler) CreateInteraction(c *gin.Context) {
 		return
 	}
 
-	reviewerID, err := uuid.Parse(reviewerIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reviewer ID"})
-		return
-	}
-
 	reviewedUserID, err := uuid.Parse(reviewedUserIDStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reviewed user ID"})
@@ -169,6 +170,21 @@ func (h *InteractionHandler) CreateInteraction(c *gin.Context) {
 		return
 	}
 
+	// Only participants of the meetup can review each other
+	meetup, err := h.meetupRepo.GetByID(meetupID)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Meetup not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve meetup"})
+		return
+	}
+	if !meetup.IsParticipant(reviewerID) || !meetup.IsParticipant(reviewedUserID) || reviewerID == reviewedUserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only review the other participant of your meetup"})
+		return
+	}
+
 	interaction := &models.Interaction{
 		ID:             uuid.New(),
 		MeetupID:       meetupID,
@@ -222,6 +238,11 @@ func (h *InteractionHandler) UpdateInteractionWithPhoto(c *gin.Context) {
 		return
 	}
 
+	if userID, _ := middleware.CurrentUserID(c); existingInteraction.ReviewerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the reviewer can update this interaction"})
+		return
+	}
+
 	// Parse form data
 	ratingStr := c.PostForm("rating")
 	reviewText := c.PostForm("review_text")
@@ -271,7 +292,7 @@ func (h *InteractionHandler) DeleteInteraction(c *gin.Context) {
 	}
 
 	// Check if interaction exists
-	_, err = h.interactionRepo.GetByID(id)
+	interaction, err := h.interactionRepo.GetByID(id)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Interaction not found"})
@@ -281,6 +302,11 @@ func (h *InteractionHandler) DeleteInteraction(c *gin.Context) {
 		return
 	}
 
+	if !isOwnerOrRole(c, interaction.ReviewerID, models.RoleModerator) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the reviewer can delete this interaction"})
+		return
+	}
+
 	if err := h.interactionRepo.Delete(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete interaction"})
 		return
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"tukarkultur/api/models"
+	"tukarkultur/api/repository"
+
+	"github.com/google/uuid"
+)
+
+// Login failure reasons recorded in the audit trail
+const (
+	loginReasonUnknownEmail     = "unknown_email"
+	loginReasonInvalidPassword  = "invalid_password"
+	loginReasonLocked           = "locked"
+	loginReasonInvalidTwoFactor = "invalid_two_factor"
+)
+
+// loginGuard throttles login attempts per account and per IP. Each failure
+// backs off exponentially (1s, 2s, 4s, ...) and reaching the failure limit
+// locks the key out, doubling the lockout on every further failure. Attempts
+// count as failed until they succeed, see begin.
+type loginGuard struct {
+	repo loginThrottleStore
+
+	maxAccountFailures int
+	maxIPFailures      int
+	failureWindow      time.Duration
+	lockoutDuration    time.Duration
+	maxLockoutDuration time.Duration
+}
+
+// loginThrottleStore is the part of repository.LoginAttemptRepository the
+// guard uses
+type loginThrottleStore interface {
+	RecordAttempt(attempt *models.LoginAttempt) error
+	Acquire(window time.Duration, backoffs map[string]func(failures int) time.Duration) (*time.Time, error)
+	Forgive(key string) error
+	Reset(key string) error
+	GetFailedAttemptsForUser(userID uuid.UUID, limit int) ([]*models.LoginAttempt, error)
+}
+
+func newLoginGuard(repo *repository.LoginAttemptRepository) *loginGuard {
+	return &loginGuard{
+		repo:               repo,
+		maxAccountFailures: intFromEnv("LOGIN_MAX_FAILURES", 5),
+		maxIPFailures:      intFromEnv("LOGIN_MAX_IP_FAILURES", 20),
+		failureWindow:      durationFromEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		lockoutDuration:    durationFromEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		maxLockoutDuration: durationFromEnv("LOGIN_MAX_LOCKOUT_DURATION", 24*time.Hour),
+	}
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// begin counts an attempt as failed against the account and IP before its
+// credentials are checked, so parallel guesses all back off as if the ones
+// before them had failed. It returns when the account or IP may try again
+// if either is locked, in which case the attempt isn't counted. Errors fail
+// open so an outage doesn't block all logins.
+func (g *loginGuard) begin(email, ip string) *time.Time {
+	lockedUntil, err := g.repo.Acquire(g.failureWindow, map[string]func(int) time.Duration{
+		accountThrottleKey(email): func(failures int) time.Duration { return g.backoff(failures, g.maxAccountFailures) },
+		ipThrottleKey(ip):         func(failures int) time.Duration { return g.backoff(failures, g.maxIPFailures) },
+	})
+	if err != nil {
+		log.Printf("Error checking login throttle for %s: %v", ip, err)
+		return nil
+	}
+	return lockedUntil
+}
+
+// fail records a failed attempt in the audit trail. begin already counted it.
+func (g *loginGuard) fail(email string, userID *uuid.UUID, ip, reason string) {
+	g.audit(email, userID, ip, false, reason)
+}
+
+// succeed records a successful login and clears the account's failure streak.
+// The IP only gets back the failure begin counted, so one good login can't
+// reset an attacker's counter for other accounts.
+func (g *loginGuard) succeed(email string, userID uuid.UUID, ip string) {
+	g.audit(email, &userID, ip, true, "")
+
+	if err := g.repo.Reset(accountThrottleKey(email)); err != nil {
+		log.Printf("Error resetting login throttle for user %s: %v", userID, err)
+	}
+	if err := g.repo.Forgive(ipThrottleKey(ip)); err != nil {
+		log.Printf("Error updating login throttle for %s: %v", ip, err)
+	}
+}
+
+// backoff returns how long key is blocked after its nth consecutive failure
+func (g *loginGuard) backoff(failures, maxFailures int) time.Duration {
+	// Computed in float so long streaks cap out instead of overflowing
+	if failures < maxFailures {
+		delay := float64(time.Second) * math.Pow(2, float64(failures-1))
+		if delay > float64(g.lockoutDuration) {
+			return g.lockoutDuration
+		}
+		return time.Duration(delay)
+	}
+
+	lockout := float64(g.lockoutDuration) * math.Pow(2, float64(failures-maxFailures))
+	if lockout > float64(g.maxLockoutDuration) {
+		return g.maxLockoutDuration
+	}
+	return time.Duration(lockout)
+}
+
+func (g *loginGuard) audit(email string, userID *uuid.UUID, ip string, success bool, reason string) {
+	attempt := &models.LoginAttempt{
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		UserID:    userID,
+		IPAddress: ip,
+		Success:   success,
+		Reason:    optionalString(reason),
+	}
+	if err := g.repo.RecordAttempt(attempt); err != nil {
+		log.Printf("Error recording login attempt from %s: %v", ip, err)
+	}
+}
+
+// intFromEnv parses an integer from the environment, falling back to def
+// when unset or invalid.
+func intFromEnv(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s %q, using default %d", key, value, def)
+		return def
+	}
+	return n
+}
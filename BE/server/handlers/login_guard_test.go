@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"tukarkultur/api/models"
+
+	"github.com/google/uuid"
+)
+
+// fakeThrottleStore keeps throttles in memory on a clock the test moves,
+// following the rules of the login_throttles queries. The mutex stands in
+// for the row locks Acquire takes.
+type fakeThrottleStore struct {
+	mu        sync.Mutex
+	now       time.Time
+	throttles map[string]*fakeThrottle
+	attempts  []*models.LoginAttempt
+}
+
+type fakeThrottle struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+func newFakeThrottleStore() *fakeThrottleStore {
+	return &fakeThrottleStore{now: time.Now(), throttles: make(map[string]*fakeThrottle)}
+}
+
+func (s *fakeThrottleStore) RecordAttempt(attempt *models.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts = append(s.attempts, attempt)
+	return nil
+}
+
+func (s *fakeThrottleStore) Acquire(window time.Duration, backoffs map[string]func(failures int) time.Duration) (*time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lockedUntil *time.Time
+	for key := range backoffs {
+		if throttle, ok := s.throttles[key]; ok && throttle.lockedUntil.After(s.now) &&
+			(lockedUntil == nil || throttle.lockedUntil.After(*lockedUntil)) {
+			until := throttle.lockedUntil
+			lockedUntil = &until
+		}
+	}
+	if lockedUntil != nil {
+		return lockedUntil, nil
+	}
+
+	for key, backoff := range backoffs {
+		throttle, ok := s.throttles[key]
+		if !ok {
+			throttle = &fakeThrottle{}
+			s.throttles[key] = throttle
+		}
+
+		last := throttle.lastFailureAt
+		if throttle.lockedUntil.After(last) {
+			last = throttle.lockedUntil
+		}
+		if last.Before(s.now.Add(-window)) {
+			throttle.failures = 1
+		} else {
+			throttle.failures++
+		}
+		throttle.lastFailureAt = s.now
+		throttle.lockedUntil = s.now.Add(backoff(throttle.failures))
+	}
+	return nil, nil
+}
+
+func (s *fakeThrottleStore) Forgive(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if throttle, ok := s.throttles[key]; ok && throttle.failures > 0 {
+		throttle.failures--
+	}
+	return nil
+}
+
+func (s *fakeThrottleStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.throttles, key)
+	return nil
+}
+
+func (s *fakeThrottleStore) GetFailedAttemptsForUser(uuid.UUID, int) ([]*models.LoginAttempt, error) {
+	return nil, nil
+}
+
+// throttle returns the state of key
+func (s *fakeThrottleStore) throttle(key string) fakeThrottle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if throttle, ok := s.throttles[key]; ok {
+		return *throttle
+	}
+	return fakeThrottle{}
+}
+
+// waitOut moves the clock past any lock on email or ip
+func (s *fakeThrottleStore) waitOut(email, ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range []string{accountThrottleKey(email), ipThrottleKey(ip)} {
+		if throttle, ok := s.throttles[key]; ok && !throttle.lockedUntil.Before(s.now) {
+			s.now = throttle.lockedUntil.Add(time.Second)
+		}
+	}
+}
+
+func newTestLoginGuard(store loginThrottleStore) *loginGuard {
+	return &loginGuard{
+		repo:               store,
+		maxAccountFailures: 5,
+		maxIPFailures:      20,
+		failureWindow:      15 * time.Minute,
+		lockoutDuration:    15 * time.Minute,
+		maxLockoutDuration: 24 * time.Hour,
+	}
+}
+
+const testEmail, testIP = "someone@example.com", "203.0.113.7"
+
+func TestLoginGuardEscalatesLockout(t *testing.T) {
+	store := newFakeThrottleStore()
+	guard := newTestLoginGuard(store)
+
+	// failOnceUnlocked waits out any lock, fails a login and returns how long
+	// the account is locked for afterwards
+	failOnceUnlocked := func() time.Duration {
+		t.Helper()
+		store.waitOut(testEmail, testIP)
+		if until := guard.begin(testEmail, testIP); until != nil {
+			t.Fatalf("begin() locked until %v after waiting the lock out", until)
+		}
+		guard.fail(testEmail, nil, testIP, loginReasonInvalidPassword)
+		return store.throttle(accountThrottleKey(testEmail)).lockedUntil.Sub(store.now)
+	}
+
+	for i := 1; i < guard.maxAccountFailures; i++ {
+		failOnceUnlocked()
+	}
+	first := failOnceUnlocked()
+	second := failOnceUnlocked()
+
+	if first != guard.lockoutDuration {
+		t.Fatalf("first lockout = %v, want %v", first, guard.lockoutDuration)
+	}
+	if second != 2*first {
+		t.Fatalf("second lockout = %v, want twice the first (%v)", second, 2*first)
+	}
+}
+
+func TestLoginGuardStreakExpires(t *testing.T) {
+	store := newFakeThrottleStore()
+	guard := newTestLoginGuard(store)
+
+	for i := 0; i < guard.maxAccountFailures; i++ {
+		store.waitOut(testEmail, testIP)
+		guard.begin(testEmail, testIP)
+	}
+
+	// A window after the lockout ran out the streak starts over
+	store.now = store.throttle(accountThrottleKey(testEmail)).lockedUntil.Add(guard.failureWindow + time.Second)
+	guard.begin(testEmail, testIP)
+
+	if failures := store.throttle(accountThrottleKey(testEmail)).failures; failures != 1 {
+		t.Fatalf("failures = %d, want the streak restarted at 1", failures)
+	}
+}
+
+func TestLoginGuardCountsParallelAttempts(t *testing.T) {
+	store := newFakeThrottleStore()
+	guard := newTestLoginGuard(store)
+
+	// Guesses sent at once mustn't all get in before the first one fails
+	const attempts = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	admitted := 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if guard.begin(testEmail, testIP) == nil {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if admitted != 1 {
+		t.Fatalf("%d of %d parallel attempts admitted, want 1", admitted, attempts)
+	}
+}
+
+func TestLoginGuardSucceed(t *testing.T) {
+	store := newFakeThrottleStore()
+	guard := newTestLoginGuard(store)
+
+	for i := 0; i < 3; i++ {
+		store.waitOut(testEmail, testIP)
+		guard.begin(testEmail, testIP)
+	}
+	guard.succeed(testEmail, uuid.New(), testIP)
+
+	if failures := store.throttle(accountThrottleKey(testEmail)).failures; failures != 0 {
+		t.Fatalf("account failures = %d after success, want 0", failures)
+	}
+	// Only the successful attempt is taken back from the IP
+	if failures := store.throttle(ipThrottleKey(testIP)).failures; failures != 2 {
+		t.Fatalf("IP failures = %d after success, want 2", failures)
+	}
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	guard := newTestLoginGuard(nil)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 15 * time.Minute},
+		{6, 30 * time.Minute},
+		{7, time.Hour},
+		{100, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := guard.backoff(tt.failures, 5); got != tt.want {
+			t.Errorf("backoff(%d, 5) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
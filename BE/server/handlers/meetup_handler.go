@@ -3,6 +3,7 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"tukarkultur/api/middleware"
 	"tukarkultur/api/models"
 	"tukarkultur/api/repository"
 
@@ -20,6 +21,12 @@ func NewMeetupHandler(meetupRepo *repository.MeetupRepository) *MeetupHandler {
 
 // POST /meetups
 func (h *MeetupHandler) CreateMeetup(c *gin.Context) {
+	userID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
 	var req models.CreateMeetupRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Printf("Error binding JSON for create meetup: %v", err)
@@ -29,14 +36,14 @@ func (h *MeetupHandler) CreateMeetup(c *gin.Context) {
 
 	log.Printf("Received create meetup request: %+v", req)
 
-	// Validate that proposed_by and proposed_to are different (if proposed_to is provided)
-	if req.ProposedTo != nil && req.ProposedBy == *req.ProposedTo {
+	// Validate that the proposer and proposed_to are different (if proposed_to is provided)
+	if req.ProposedTo != nil && userID == *req.ProposedTo {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot propose meetup to yourself"})
 		return
 	}
 
 	meetup := &models.Meetup{
-		ProposedBy:      req.ProposedBy,
+		ProposedBy:      userID,
 		ProposedTo:      req.ProposedTo,
 		LocationName:    req.LocationName,
 		LocationAddress: req.LocationAddress,
@@ -146,6 +153,11 @@ func (h *MeetupHandler) UpdateMeetup(c *gin.Context) {
 		return
 	}
 
+	if !isOwnerOrRole(c, existingMeetup.ProposedBy, models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the proposer can update this meetup"})
+		return
+	}
+
 	var req models.UpdateMeetupRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -195,7 +207,7 @@ func (h *MeetupHandler) DeleteMeetup(c *gin.Context) {
 	}
 
 	// Check if meetup exists
-	_, err = h.meetupRepo.GetByID(id)
+	meetup, err := h.meetupRepo.GetByID(id)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Meetup not found"})
@@ -205,6 +217,11 @@ func (h *MeetupHandler) DeleteMeetup(c *gin.Context) {
 		return
 	}
 
+	if !isOwnerOrRole(c, meetup.ProposedBy, models.RoleModerator) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the proposer can delete this meetup"})
+		return
+	}
+
 	if err := h.meetupRepo.Delete(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete meetup"})
 		return
@@ -235,6 +252,12 @@ func (h *MeetupHandler) CompleteMeetup(c *gin.Context) {
 		return
 	}
 
+	userID, _ := middleware.CurrentUserID(c)
+	if !existingMeetup.IsParticipant(userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only participants can complete this meetup"})
+		return
+	}
+
 	log.Printf("Current meetup status: '%s'", existingMeetup.Status)
 
 	// Only allow completing if current status is "confirmed"
@@ -282,6 +305,14 @@ func (h *MeetupHandler) ConfirmMeetup(c *gin.Context) {
 		return
 	}
 
+	// Only the invited user confirms; open proposals can be taken by anyone else
+	userID, _ := middleware.CurrentUserID(c)
+	if existingMeetup.ProposedBy == userID ||
+		(existingMeetup.ProposedTo != nil && *existingMeetup.ProposedTo != userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the invited user can confirm this meetup"})
+		return
+	}
+
 	// Only allow confirming if current status is "proposed"
 	if existingMeetup.Status != "proposed" {
 		c.JSON(http.StatusBadRequest, gin.H{
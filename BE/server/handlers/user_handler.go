@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"log"
 	"math"
 	"net/http"
+	"net/mail"
 	"sort"
+	"tukarkultur/api/middleware"
 	"tukarkultur/api/models"
 	"tukarkultur/api/repository"
 	"tukarkultur/api/services"
@@ -16,12 +21,16 @@ import (
 type UserHandler struct {
 	userRepo          *repository.UserRepository
 	cloudinaryService *services.CloudinaryService
+	presenceService   *services.PresenceService
+	emailVerifier     *EmailVerifier
 }
 
-func NewUserHandler(userRepo *repository.UserRepository, cloudinaryService *services.CloudinaryService) *UserHandler {
+func NewUserHandler(userRepo *repository.UserRepository, cloudinaryService *services.CloudinaryService, presenceService *services.PresenceService, emailVerifier *EmailVerifier) *UserHandler {
 	return &UserHandler{
 		userRepo:          userRepo,
 		cloudinaryService: cloudinaryService,
+		presenceService:   presenceService,
+		emailVerifier:     emailVerifier,
 	}
 }
 
@@ -80,11 +89,38 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 		return
 	}
 
-	// Remove password hash from response
-	user.PasswordHash = ""
+	redactUser(c, user)
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
+// GET /users/:id/presence
+// Users who hide their online status always appear offline to others.
+func (h *UserHandler) GetPresence(c *gin.Context) {
+	viewerID, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	presence, err := h.presenceService.Get(id, viewerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve presence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, presence)
+}
+
 // GET /users
 func (h *UserHandler) GetAllUsers(c *gin.Context) {
 	users, err := h.userRepo.GetAll()
@@ -93,9 +129,9 @@ func (h *UserHandler) GetAllUsers(c *gin.Context) {
 		return
 	}
 
-	// Remove password hashes from response
+	// Emails are only visible to their owner and moderators
 	for _, user := range users {
-		user.PasswordHash = ""
+		redactUser(c, user)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"users": users})
@@ -110,6 +146,11 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if !isOwnerOrRole(c, id, models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own account"})
+		return
+	}
+
 	// Get existing user
 	user, err := h.userRepo.GetByID(id)
 	if err != nil {
@@ -123,6 +164,156 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	email := user.Email
+	applyUserUpdates(user, updateData)
+	if !h.saveUser(c, user, email, "Failed to update user") {
+		return
+	}
+
+	user.PasswordHash = ""
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// PUT /users/profile
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	id, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := h.userRepo.GetByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var updateData map[string]interface{}
+	if err := c.ShouldBindJSON(&updateData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	email := user.Email
+	applyUserUpdates(user, updateData)
+	if !h.saveUser(c, user, email, "Failed to update profile") {
+		return
+	}
+
+	user.PasswordHash = ""
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    user,
+	})
+}
+
+// GET /users/settings
+func (h *UserHandler) GetSettings(c *gin.Context) {
+	id, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	settings, err := h.userRepo.GetSettings(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// PUT /users/settings
+// Only the provided fields are changed.
+func (h *UserHandler) UpdateSettings(c *gin.Context) {
+	id, ok := middleware.CurrentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.PreferredLanguage != nil && *req.PreferredLanguage != "" {
+		if _, ok := models.ChatLanguages[*req.PreferredLanguage]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported preferred language"})
+			return
+		}
+	}
+
+	settings, err := h.userRepo.GetSettings(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve settings"})
+		return
+	}
+
+	if req.ReadReceipts != nil {
+		settings.ReadReceipts = *req.ReadReceipts
+	}
+	onlineStatusChanged := req.OnlineStatus != nil && *req.OnlineStatus != settings.OnlineStatus
+	if req.OnlineStatus != nil {
+		settings.OnlineStatus = *req.OnlineStatus
+	}
+	if req.PreferredLanguage != nil {
+		settings.PreferredLanguage = req.PreferredLanguage
+		if *req.PreferredLanguage == "" {
+			settings.PreferredLanguage = nil
+		}
+	}
+
+	if err := h.userRepo.UpdateSettings(settings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	if onlineStatusChanged {
+		h.presenceService.Refresh(id)
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// saveUser stores user after applyUserUpdates, responding with the error if
+// it can't. A new email must be a valid address no one else uses, and stays
+// unverified until the user follows the link mailed to it; failing to mail
+// it doesn't fail the update, the user can ask for a resend.
+func (h *UserHandler) saveUser(c *gin.Context, user *models.User, previousEmail, failure string) bool {
+	emailChanged := user.Email != previousEmail
+	if emailChanged && !isEmailAddress(user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return false
+	}
+
+	if err := h.userRepo.Update(user); err != nil {
+		if errors.Is(err, repository.ErrDuplicateUser) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email or username already exists"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return false
+	}
+
+	if emailChanged {
+		if err := h.emailVerifier.send(user, true); err != nil {
+			log.Printf("Error sending verification email to user %s: %v", user.ID, err)
+		}
+	}
+	return true
+}
+
+// isEmailAddress reports whether value is a bare email address
+func isEmailAddress(value string) bool {
+	address, err := mail.ParseAddress(value)
+	return err == nil && address.Address == value
+}
+
+// applyUserUpdates copies the provided profile fields onto user
+func applyUserUpdates(user *models.User, updateData map[string]interface{}) {
 	// Update only provided fields
 	if username, exists := updateData["username"]; exists {
 		if str, ok := username.(string); ok {
@@ -154,14 +345,6 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 			user.Country = &str
 		}
 	}
-
-	if err := h.userRepo.Update(user); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
-		return
-	}
-
-	user.PasswordHash = ""
-	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
 // PUT /users/location/:id
@@ -173,6 +356,11 @@ func (h *UserHandler) UpdateLocation(c *gin.Context) {
 		return
 	}
 
+	if !isOwnerOrRole(c, id, models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own location"})
+		return
+	}
+
 	var req models.UpdateLocationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -260,6 +448,11 @@ func (h *UserHandler) UploadProfilePicture(c *gin.Context) {
 		return
 	}
 
+	if !isOwnerOrRole(c, userID, models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own profile picture"})
+		return
+	}
+
 	file, _, err := c.Request.FormFile("profile_picture")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
@@ -303,6 +496,11 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	if !isOwnerOrRole(c, id, models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own account"})
+		return
+	}
+
 	if err := h.userRepo.Delete(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
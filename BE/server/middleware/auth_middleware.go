@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"tukarkultur/api/models"
+	"tukarkultur/api/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Context keys set by RequireAuth for downstream handlers
+const (
+	ContextUserKey    = "auth_user"
+	ContextUserIDKey  = "auth_user_id"
+	ContextSessionKey = "auth_session"
+)
+
+var (
+	ErrInvalidToken     = errors.New("invalid or expired token")
+	ErrAccountSuspended = errors.New("account suspended")
+	ErrEmailNotVerified = errors.New("please verify your email address first")
+)
+
+type AuthMiddleware struct {
+	authRepo             *repository.AuthRepository
+	userRepo             *repository.UserRepository
+	requireVerifiedEmail bool
+}
+
+func NewAuthMiddleware(authRepo *repository.AuthRepository, userRepo *repository.UserRepository) *AuthMiddleware {
+	return &AuthMiddleware{
+		authRepo:             authRepo,
+		userRepo:             userRepo,
+		requireVerifiedEmail: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+	}
+}
+
+// RequireAuth resolves the Authorization: Bearer token to a session and stores
+// the session and its user in the request context. Routes listed in
+// publicRoutes (matched against the registered route path) are let through
+// without a token.
+func (m *AuthMiddleware) RequireAuth(publicRoutes ...string) gin.HandlerFunc {
+	public := make(map[string]bool, len(publicRoutes))
+	for _, route := range publicRoutes {
+		public[route] = true
+	}
+
+	return func(c *gin.Context) {
+		if public[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		token := BearerToken(c)
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization token is required"})
+			return
+		}
+
+		session, user, err := m.Authenticate(token, c.ClientIP())
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidToken):
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			case errors.Is(err, ErrAccountSuspended):
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "Account suspended",
+					"code":  "account_suspended",
+				})
+			default:
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate session"})
+			}
+			return
+		}
+
+		c.Set(ContextSessionKey, session)
+		c.Set(ContextUserKey, user)
+		c.Set(ContextUserIDKey, user.ID)
+		c.Next()
+	}
+}
+
+// Authenticate resolves a session token to its session and user, as
+// RequireAuth does for bearer tokens. It is also used by connections that
+// can't send headers, like the chat WebSocket's first frame.
+func (m *AuthMiddleware) Authenticate(token, clientIP string) (*models.AuthSession, *models.User, error) {
+	session, err := m.authRepo.GetSessionByToken(token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, fmt.Errorf("failed to validate session: %w", err)
+	}
+
+	user, err := m.userRepo.GetByID(session.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrInvalidToken
+		}
+		return nil, nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	if user.IsSuspended() {
+		return nil, nil, ErrAccountSuspended
+	}
+
+	if err := m.authRepo.TouchSession(session.ID, clientIP); err != nil {
+		log.Printf("Error updating last seen for session %d: %v", session.ID, err)
+	}
+
+	// Never expose the password hash to handlers that echo the user back
+	user.PasswordHash = ""
+
+	return session, user, nil
+}
+
+// RequireVerifiedEmail rejects users whose email isn't verified yet when the
+// REQUIRE_EMAIL_VERIFICATION policy is enabled. Must run after RequireAuth.
+func (m *AuthMiddleware) RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.requireVerifiedEmail {
+			c.Next()
+			return
+		}
+
+		user, ok := CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if !m.emailVerified(user) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Please verify your email address first",
+				"code":  "email_not_verified",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// CheckVerifiedEmail applies the policy of RequireVerifiedEmail outside of
+// HTTP routes, like meetups proposed in chat. It returns ErrEmailNotVerified
+// if the user may not go on.
+func (m *AuthMiddleware) CheckVerifiedEmail(userID uuid.UUID) error {
+	if !m.requireVerifiedEmail {
+		return nil
+	}
+
+	user, err := m.userRepo.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	if !m.emailVerified(user) {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// emailVerified reports whether user passes the REQUIRE_EMAIL_VERIFICATION
+// policy
+func (m *AuthMiddleware) emailVerified(user *models.User) bool {
+	return !m.requireVerifiedEmail || user.EmailVerifiedAt != nil
+}
+
+// RequireRole rejects users whose role is below role (admins pass every
+// moderator check). Must run after RequireAuth.
+func (m *AuthMiddleware) RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if !user.HasRole(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// BearerToken extracts the token from the Authorization header, or returns
+// an empty string if the header is missing or not a bearer token.
+func BearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// CurrentUserID returns the ID of the authenticated user for this request.
+func CurrentUserID(c *gin.Context) (uuid.UUID, bool) {
+	value, exists := c.Get(ContextUserIDKey)
+	if !exists {
+		return uuid.Nil, false
+	}
+	id, ok := value.(uuid.UUID)
+	return id, ok
+}
+
+// CurrentUser returns the authenticated user for this request.
+func CurrentUser(c *gin.Context) (*models.User, bool) {
+	value, exists := c.Get(ContextUserKey)
+	if !exists {
+		return nil, false
+	}
+	user, ok := value.(*models.User)
+	return user, ok
+}
+
+// CurrentSession returns the session that authenticated this request.
+func CurrentSession(c *gin.Context) (*models.AuthSession, bool) {
+	value, exists := c.Get(ContextSessionKey)
+	if !exists {
+		return nil, false
+	}
+	session, ok := value.(*models.AuthSession)
+	return session, ok
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Conversation types
+const (
+	ConversationTypeDirect = "direct"
+	ConversationTypeGroup  = "group"
+)
+
+// Participant roles. Owners manage a group's title and members.
+const (
+	ParticipantRoleOwner  = "owner"
+	ParticipantRoleMember = "member"
+)
+
+// MaxGroupParticipants caps the size of group conversations
+const MaxGroupParticipants = 50
+
+// directConversationNamespace seeds the deterministic IDs of one-to-one
+// conversations, see DirectConversationID.
+var directConversationNamespace = uuid.MustParse("5b0c6f4e-8f0a-4c1e-9d53-2a7e6c1b9f40")
+
+// Conversation is a one-to-one chat between two users or a group chat
+type Conversation struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	Type      string     `json:"type" db:"type"` // direct, group
+	Title     *string    `json:"title,omitempty" db:"title"`
+	MeetupID  *uuid.UUID `json:"meetup_id,omitempty" db:"meetup_id"`
+	CreatedBy *uuid.UUID `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+
+	// Filled in depending on the endpoint
+	PeerID       *uuid.UUID                 `json:"peer_id,omitempty"` // the other user of a direct conversation
+	Participants []*ConversationParticipant `json:"participants,omitempty"`
+	UnreadCount  int                        `json:"unread_count"`
+	LastMessage  *Message                   `json:"last_message"`
+
+	LastMessageID *uuid.UUID `json:"-"`
+}
+
+type ConversationParticipant struct {
+	UserID   uuid.UUID `json:"user_id" db:"user_id"`
+	Role     string    `json:"role" db:"role"` // owner, member
+	JoinedAt time.Time `json:"joined_at" db:"joined_at"`
+	// Whether the user has incoming messages translated
+	Translate bool `json:"translate" db:"translate"`
+}
+
+// IsParticipant reports whether userID takes part in the conversation.
+// Participants must be loaded.
+func (c *Conversation) IsParticipant(userID uuid.UUID) bool {
+	return c.Participant(userID) != nil
+}
+
+// Participant returns userID's membership, or nil if they aren't a participant
+func (c *Conversation) Participant(userID uuid.UUID) *ConversationParticipant {
+	for _, participant := range c.Participants {
+		if participant.UserID == userID {
+			return participant
+		}
+	}
+	return nil
+}
+
+// ParticipantIDs returns the IDs of the loaded participants
+func (c *Conversation) ParticipantIDs() []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(c.Participants))
+	for _, participant := range c.Participants {
+		ids = append(ids, participant.UserID)
+	}
+	return ids
+}
+
+// CreateConversationRequest creates a group conversation owned by the
+// authenticated user. With a meetup_id, the meetup's participants are added.
+type CreateConversationRequest struct {
+	Title          *string     `json:"title,omitempty" binding:"omitempty,max=100"`
+	ParticipantIDs []uuid.UUID `json:"participant_ids,omitempty"`
+	MeetupID       *uuid.UUID  `json:"meetup_id,omitempty"`
+}
+
+type UpdateConversationRequest struct {
+	Title *string `json:"title" binding:"required,max=100"`
+}
+
+type AddParticipantsRequest struct {
+	UserIDs []uuid.UUID `json:"user_ids" binding:"required,min=1"`
+}
+
+type TransferOwnershipRequest struct {
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+}
+
+// DirectConversationID returns the conversation ID shared by two users. It is
+// derived from the pair, so both sides get the same ID without a lookup.
+func DirectConversationID(a, b uuid.UUID) uuid.UUID {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return uuid.NewSHA1(directConversationNamespace, append(a[:], b[:]...))
+}
@@ -18,9 +18,9 @@ type Interaction struct {
 	CreatedAt           time.Time `json:"created_at" db:"created_at"`
 }
 
+// CreateInteractionRequest is reviewed by the authenticated user
 type CreateInteractionRequest struct {
 	MeetupID            uuid.UUID `json:"meetup_id" binding:"required"`
-	ReviewerID          uuid.UUID `json:"reviewer_id" binding:"required"`
 	ReviewedUserID      uuid.UUID `json:"reviewed_user_id" binding:"required"`
 	Rating              int       `json:"rating" binding:"required,min=1,max=5"`
 	MeetupPhotoURL      *string   `json:"meetup_photo_url,omitempty"`
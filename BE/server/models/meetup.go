@@ -17,8 +17,13 @@ type Meetup struct {
 	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
 }
 
+// IsParticipant reports whether userID proposed the meetup or was invited to it
+func (m *Meetup) IsParticipant(userID uuid.UUID) bool {
+	return m.ProposedBy == userID || (m.ProposedTo != nil && *m.ProposedTo == userID)
+}
+
+// CreateMeetupRequest is proposed by the authenticated user
 type CreateMeetupRequest struct {
-	ProposedBy      uuid.UUID  `json:"proposed_by" binding:"required"`
 	ProposedTo      *uuid.UUID `json:"proposed_to,omitempty"`
 	LocationName    *string    `json:"location_name,omitempty"`
 	LocationAddress *string    `json:"location_address,omitempty"`
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Message types
+const (
+	MessageTypeText           = "text"
+	MessageTypeImage          = "image"
+	MessageTypeLocation       = "location"
+	MessageTypeMeetupProposal = "meetup_proposal"
+)
+
+// Message is a persisted chat message. The JSON field names match the
+// WebSocket frame so clients can parse history and live messages alike.
+type Message struct {
+	ID                 uuid.UUID  `json:"id" db:"id"`
+	ConversationID     uuid.UUID  `json:"conversation_id" db:"conversation_id"`
+	SenderID           uuid.UUID  `json:"sender" db:"sender_id"`
+	ReceiverID         *uuid.UUID `json:"receiver,omitempty" db:"receiver_id"` // null in group conversations
+	MessageText        *string    `json:"text,omitempty" db:"message_text"`    // null for image messages
+	MessageType        string     `json:"message_type" db:"message_type"`      // text, image, location, meetup_proposal
+	ImageURL           *string    `json:"image_url,omitempty" db:"image_url"`
+	CloudinaryPublicID *string    `json:"-" db:"cloudinary_public_id"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+
+	// Seq is the message's position in the requesting user's inbox. Each
+	// participant sees the same message under their own sequence number.
+	Seq int64 `json:"seq,omitempty" db:"seq"`
+
+	// When the receiver of a direct message got and read it. ReadAt stays
+	// hidden from the sender if the receiver turned read receipts off.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at,omitempty" db:"read_at"`
+
+	// Payloads of the other message types. Meetup is the proposed meetup as
+	// it is now, so clients can tell whether it was accepted yet.
+	Location *MessageLocation `json:"location,omitempty"`
+	MeetupID *uuid.UUID       `json:"meetup_id,omitempty" db:"meetup_id"`
+	Meetup   *Meetup          `json:"meetup,omitempty"`
+
+	// When the sender last edited the message, or deleted it for everyone,
+	// which clears its content
+	EditedAt  *time.Time `json:"edited_at,omitempty" db:"edited_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+
+	Reactions []*MessageReaction `json:"reactions,omitempty"`
+
+	// The text in the requesting user's preferred language, if they turned
+	// translation on in the conversation and it was translated
+	Translation *MessageTranslation `json:"translation,omitempty"`
+}
+
+// MessageReaction is an emoji reaction to a message with the users who
+// reacted with it, in the order they did
+type MessageReaction struct {
+	Emoji   string      `json:"emoji"`
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+// MessageEdit is a text a message had before it was edited or deleted for
+// everyone, kept for moderation
+type MessageEdit struct {
+	MessageText *string   `json:"text" db:"message_text"`
+	ReplacedAt  time.Time `json:"replaced_at" db:"replaced_at"`
+}
+
+// MessageLocation is the pin of a location message
+type MessageLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      *string `json:"name,omitempty"`
+}
+
+// Limits checked by Message.Validate and ValidateReaction
+const (
+	MaxMessageTextLength  = 4000
+	MaxLocationNameLength = 255
+	MaxReactionLength     = 16
+)
+
+// DeleteForEveryoneWindow is how long after sending a message its sender can
+// still delete it for everyone. Deleting it for themselves works any time.
+const DeleteForEveryoneWindow = time.Hour
+
+// ErrInvalidMessage wraps the reasons Validate rejects a message for
+var ErrInvalidMessage = errors.New("invalid message")
+
+// Validate checks that the message carries the payload its type calls for.
+// A meetup proposal needs its MeetupID, or the Meetup it is about to create.
+func (m *Message) Validate() error {
+	if m.MessageText != nil && utf8.RuneCountInString(*m.MessageText) > MaxMessageTextLength {
+		return fmt.Errorf("%w: text is longer than %d characters", ErrInvalidMessage, MaxMessageTextLength)
+	}
+
+	switch m.MessageType {
+	case MessageTypeText:
+		if m.MessageText == nil || strings.TrimSpace(*m.MessageText) == "" {
+			return fmt.Errorf("%w: text is required", ErrInvalidMessage)
+		}
+	case MessageTypeImage:
+		if m.ImageURL == nil || m.CloudinaryPublicID == nil {
+			return fmt.Errorf("%w: image_url and image_public_id are required", ErrInvalidMessage)
+		}
+	case MessageTypeLocation:
+		if m.Location == nil {
+			return fmt.Errorf("%w: location is required", ErrInvalidMessage)
+		}
+		if m.Location.Latitude < -90 || m.Location.Latitude > 90 ||
+			m.Location.Longitude < -180 || m.Location.Longitude > 180 {
+			return fmt.Errorf("%w: location is out of range", ErrInvalidMessage)
+		}
+		if m.Location.Name != nil && utf8.RuneCountInString(*m.Location.Name) > MaxLocationNameLength {
+			return fmt.Errorf("%w: location name is longer than %d characters", ErrInvalidMessage, MaxLocationNameLength)
+		}
+	case MessageTypeMeetupProposal:
+		if m.MeetupID == nil && m.Meetup == nil {
+			return fmt.Errorf("%w: meetup is required", ErrInvalidMessage)
+		}
+	default:
+		return fmt.Errorf("%w: unknown message type %q", ErrInvalidMessage, m.MessageType)
+	}
+	return nil
+}
+
+// ValidateReaction checks an emoji reaction: a single short token without
+// spaces, as clients send the emoji itself
+func ValidateReaction(emoji string) error {
+	if emoji == "" {
+		return fmt.Errorf("%w: emoji is required", ErrInvalidMessage)
+	}
+	if !utf8.ValidString(emoji) || utf8.RuneCountInString(emoji) > MaxReactionLength ||
+		strings.IndexFunc(emoji, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("%w: invalid emoji %q", ErrInvalidMessage, emoji)
+	}
+	return nil
+}
+
+// MessageSearchResult is a message matching a chat search, with a snippet
+// of its text as HTML: the text is escaped and the matches are wrapped in
+// <mark> tags.
+type MessageSearchResult struct {
+	Message *Message `json:"message"`
+	Snippet string   `json:"snippet"`
+}
+
+// MaxSearchQueryLength caps the length of chat search queries
+const MaxSearchQueryLength = 200
+
+// MessageReceipt reports that a message reached one of its recipients, the
+// ReceiverID, or was read by them. A read receipt covers every earlier
+// message the recipient got from the same sender in the conversation too.
+type MessageReceipt struct {
+	MessageID      uuid.UUID
+	ConversationID uuid.UUID
+	SenderID       uuid.UUID
+	ReceiverID     uuid.UUID
+	At             time.Time
+}
+
+// ChatImageFolder is the Cloudinary folder the images userID sends in chat
+// are uploaded to
+func ChatImageFolder(userID uuid.UUID) string {
+	return "chat/" + userID.String()
+}
+
+// MessageCursor marks a position in a conversation's history, newest first
+type MessageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Encode returns the opaque cursor string handed to clients
+func (c MessageCursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeMessageCursor parses a cursor produced by MessageCursor.Encode
+func DecodeMessageCursor(value string) (*MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	createdAt, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := &MessageCursor{}
+	if cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.ID, err = uuid.Parse(id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
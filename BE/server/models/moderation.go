@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Content filter actions, from least to most severe. Masked words are
+// starred out; flagged messages are sent but recorded for moderator review;
+// blocked ones are recorded and never sent.
+const (
+	ModerationAllow = "allow"
+	ModerationMask  = "mask"
+	ModerationFlag  = "flag"
+	ModerationBlock = "block"
+)
+
+var moderationSeverity = map[string]int{
+	ModerationAllow: 0,
+	ModerationMask:  1,
+	ModerationFlag:  2,
+	ModerationBlock: 3,
+}
+
+// ModerationSeverity ranks a content filter action, unknown ones as allow
+func ModerationSeverity(action string) int {
+	return moderationSeverity[action]
+}
+
+// ModerationResult is a content filter's verdict on a text
+type ModerationResult struct {
+	Action  string   // the strictest action any rule called for
+	Text    string   // the text to send, with masked words starred out
+	Reasons []string // why, e.g. the matched word list entries
+	Source  string   // the filter that chose the action, e.g. wordlist or ai
+}
+
+// Review states of a flagged message
+const (
+	FlagStatusPending   = "pending"
+	FlagStatusUpheld    = "upheld"
+	FlagStatusDismissed = "dismissed"
+)
+
+// FlaggedMessage is a chat message the content filter flagged or blocked,
+// kept with its original text for moderators. Blocked messages were never
+// stored, so they have no MessageID.
+type FlaggedMessage struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	MessageID      *uuid.UUID `json:"message_id,omitempty" db:"message_id"`
+	ConversationID uuid.UUID  `json:"conversation_id" db:"conversation_id"`
+	SenderID       uuid.UUID  `json:"sender_id" db:"sender_id"`
+	MessageText    string     `json:"text" db:"message_text"`
+	Action         string     `json:"action" db:"action"` // flag, block
+	Reasons        []string   `json:"reasons" db:"reasons"`
+	Source         string     `json:"source" db:"source"`
+	Status         string     `json:"status" db:"status"` // pending, upheld, dismissed
+	ReviewedBy     *uuid.UUID `json:"reviewed_by,omitempty" db:"reviewed_by"`
+	ReviewedAt     *time.Time `json:"reviewed_at,omitempty" db:"reviewed_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+type ReviewFlagRequest struct {
+	Status string `json:"status" binding:"required,oneof=upheld dismissed"`
+}
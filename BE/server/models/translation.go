@@ -0,0 +1,29 @@
+package models
+
+// ChatLanguages are the languages chat can be translated into, by code,
+// with the name given to the translator
+var ChatLanguages = map[string]string{
+	"ar": "Arabic",
+	"de": "German",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"id": "Indonesian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"ms": "Malay",
+	"th": "Thai",
+	"vi": "Vietnamese",
+	"zh": "Chinese",
+}
+
+// MessageTranslation is the text of a message translated into the
+// receiver's preferred language
+type MessageTranslation struct {
+	Language string `json:"language"`
+	Text     string `json:"text"`
+}
+
+type UpdateTranslationRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
@@ -11,7 +11,11 @@ type User struct {
 	ID                uuid.UUID      `json:"id" db:"id"`
 	Username          string         `json:"username" db:"username"`
 	Email             string         `json:"email" db:"email"`
+	EmailVerifiedAt   *time.Time     `json:"email_verified_at,omitempty" db:"email_verified_at"`
 	PasswordHash      string         `json:"-" db:"password_hash"` // Hidden from JSON
+	Role              string         `json:"role" db:"role"`       // user, moderator, admin
+	SuspendedAt       *time.Time     `json:"suspended_at,omitempty" db:"suspended_at"`
+	SuspensionReason  *string        `json:"suspension_reason,omitempty" db:"suspension_reason"`
 	FullName          string         `json:"full_name" db:"full_name"`
 	ProfilePictureURL *string        `json:"profile_picture_url,omitempty" db:"profile_picture_url"`
 	Bio               *string        `json:"bio,omitempty" db:"bio"`
@@ -28,6 +32,35 @@ type User struct {
 	CreatedAt         time.Time      `json:"created_at" db:"created_at"`
 }
 
+// User role constants, each role includes the permissions of the ones before it
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether the user's role is at least role
+func (u *User) HasRole(role string) bool {
+	return roleRank[u.Role] >= roleRank[role]
+}
+
+// IsSuspended reports whether a moderator has suspended the account
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
 type CreateUserRequest struct {
 	Username  string         `json:"username" binding:"required,min=3,max=50"`
 	Email     string         `json:"email" binding:"required,email"`
@@ -56,3 +89,46 @@ type NearbyUserResponse struct {
 	UpdatedAt         time.Time  `json:"updated_at"`
 	LocationUpdatedAt *time.Time `json:"location_updated_at,omitempty"`
 }
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user moderator admin"`
+}
+
+// UserSettings holds a user's privacy preferences
+type UserSettings struct {
+	UserID       uuid.UUID `json:"user_id" db:"user_id"`
+	ReadReceipts bool      `json:"read_receipts" db:"read_receipts"`
+	OnlineStatus bool      `json:"online_status" db:"online_status"`
+	// The language chat is translated into in the conversations the user
+	// turned translation on in, see ChatLanguages
+	PreferredLanguage *string   `json:"preferred_language" db:"preferred_language"`
+	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// DefaultUserSettings returns the settings of a user who never changed them
+func DefaultUserSettings(userID uuid.UUID) *UserSettings {
+	return &UserSettings{
+		UserID:       userID,
+		ReadReceipts: true,
+		OnlineStatus: true,
+	}
+}
+
+type UpdateSettingsRequest struct {
+	ReadReceipts *bool `json:"read_receipts,omitempty"`
+	OnlineStatus *bool `json:"online_status,omitempty"`
+	// An empty string clears it
+	PreferredLanguage *string `json:"preferred_language,omitempty"`
+}
+
+// Presence is whether a user has a chat connection open, and when their
+// last one closed
+type Presence struct {
+	UserID     uuid.UUID  `json:"user_id"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
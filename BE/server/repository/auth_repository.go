@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 	"tukarkultur/api/models"
@@ -9,6 +11,15 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+// ErrRefreshTokenReused is returned when a refresh token that was already
+// rotated (or revoked) is presented again.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+// rowQuerier is satisfied by both *sqlx.DB and *sqlx.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 type AuthRepository struct {
 	db *sqlx.DB
 }
@@ -17,17 +28,116 @@ func NewAuthRepository(db *sqlx.DB) *AuthRepository {
 	return &AuthRepository{db: db}
 }
 
-func (r *AuthRepository) CreateSession(userID uuid.UUID, token string) error {
-	expiresAt := time.Now().Add(24 * time.Hour)
-	query := `INSERT INTO auth_sessions (user_id, token, expires_at) VALUES ($1, $2, $3)`
+func (r *AuthRepository) CreateSession(session *models.AuthSession) error {
+	return insertSession(r.db, session)
+}
 
-	_, err := r.db.Exec(query, userID, token, expiresAt)
-	return err
+func insertSession(q rowQuerier, session *models.AuthSession) error {
+	query := `INSERT INTO auth_sessions (user_id, token, family_id, device_name, user_agent, ip_address, last_seen_at, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7)
+              RETURNING id, last_seen_at, created_at, updated_at`
+
+	err := q.QueryRow(query,
+		session.UserID, session.Token, session.FamilyID,
+		session.DeviceName, session.UserAgent, session.IPAddress, session.ExpiresAt,
+	).Scan(&session.ID, &session.LastSeenAt, &session.CreatedAt, &session.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// DeleteSession ends the session for token, along with its refresh token
+// family. It reports whether the token matched a session.
+func (r *AuthRepository) DeleteSession(token string) (bool, error) {
+	var familyID *uuid.UUID
+	query := `DELETE FROM auth_sessions WHERE token = $1 RETURNING family_id`
+	err := r.db.QueryRow(query, token).Scan(&familyID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if familyID != nil {
+		if err := r.RevokeRefreshFamily(*familyID); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// DeleteUserSession ends one of the user's sessions by ID, along with its
+// refresh token family. It reports whether such a session existed.
+func (r *AuthRepository) DeleteUserSession(userID uuid.UUID, sessionID uint) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var familyID *uuid.UUID
+	query := `DELETE FROM auth_sessions WHERE id = $1 AND user_id = $2 RETURNING family_id`
+	err = tx.QueryRow(query, sessionID, userID).Scan(&familyID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if familyID != nil {
+		query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+		if _, err = tx.Exec(query, *familyID); err != nil {
+			return false, err
+		}
+		query = `DELETE FROM auth_sessions WHERE family_id = $1`
+		if _, err = tx.Exec(query, *familyID); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// GetUserSessions lists the user's unexpired sessions, most recently used first
+func (r *AuthRepository) GetUserSessions(userID uuid.UUID) ([]*models.AuthSession, error) {
+	query := `SELECT id, user_id, token, family_id, device_name, user_agent, ip_address, last_seen_at,
+                     expires_at, created_at, updated_at
+              FROM auth_sessions
+              WHERE user_id = $1 AND expires_at > NOW()
+              ORDER BY last_seen_at DESC NULLS LAST, created_at DESC`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.AuthSession
+	for rows.Next() {
+		session := &models.AuthSession{}
+		err := rows.Scan(
+			&session.ID, &session.UserID, &session.Token, &session.FamilyID,
+			&session.DeviceName, &session.UserAgent, &session.IPAddress, &session.LastSeenAt,
+			&session.ExpiresAt, &session.CreatedAt, &session.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
 }
 
-func (r *AuthRepository) DeleteSession(token string) error {
-	query := `DELETE FROM auth_sessions WHERE token = $1`
-	_, err := r.db.Exec(query, token)
+// TouchSession records activity on a session. Writes are throttled to once a
+// minute so authenticated requests don't each cost an UPDATE.
+func (r *AuthRepository) TouchSession(sessionID uint, ipAddress string) error {
+	query := `UPDATE auth_sessions SET last_seen_at = NOW(), ip_address = $2
+              WHERE id = $1 AND (last_seen_at IS NULL OR last_seen_at < NOW() - INTERVAL '1 minute')`
+	_, err := r.db.Exec(query, sessionID, ipAddress)
 	return err
 }
 
@@ -39,14 +149,146 @@ func (r *AuthRepository) GetPasswordHash(email string) (string, error) {
 	return passwordHash, err
 }
 
+func (r *AuthRepository) GetPasswordHashByUserID(userID uuid.UUID) (string, error) {
+	var passwordHash string
+	query := `SELECT password_hash FROM users WHERE id = $1`
+
+	err := r.db.QueryRow(query, userID).Scan(&passwordHash)
+	return passwordHash, err
+}
+
+func (r *AuthRepository) UpdatePasswordHash(userID uuid.UUID, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Exec(query, userID, passwordHash)
+	return err
+}
+
+// CreatePasswordResetToken stores a new reset token, invalidating any
+// unused tokens the user requested earlier.
+func (r *AuthRepository) CreatePasswordResetToken(token *models.PasswordResetToken) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL`
+	if _, err = tx.Exec(query, token.UserID); err != nil {
+		return err
+	}
+
+	token.ID = uuid.New()
+	query = `INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at)
+             VALUES ($1, $2, $3, $4)
+             RETURNING created_at`
+	err = tx.QueryRow(query, token.ID, token.UserID, token.TokenHash, token.ExpiresAt).Scan(&token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create password reset token: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ResetPassword consumes an unused, unexpired reset token and sets the new
+// password hash for its user in one transaction. It returns the user ID, or
+// sql.ErrNoRows if the token is unknown, used or expired.
+func (r *AuthRepository) ResetPassword(tokenHash, passwordHash string) (uuid.UUID, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	// Marking the token used in the same statement that checks it makes it single-use
+	var userID uuid.UUID
+	query := `UPDATE password_reset_tokens SET used_at = NOW()
+              WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+              RETURNING user_id`
+	if err = tx.QueryRow(query, tokenHash).Scan(&userID); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to consume password reset token: %w", err)
+	}
+
+	query = `UPDATE users SET password_hash = $2, updated_at = NOW() WHERE id = $1`
+	if _, err = tx.Exec(query, userID, passwordHash); err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// CreateEmailVerificationToken stores a new verification token, replacing
+// any unused tokens sent to the user earlier.
+func (r *AuthRepository) CreateEmailVerificationToken(token *models.EmailVerificationToken) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM email_verification_tokens WHERE user_id = $1 AND used_at IS NULL`
+	if _, err = tx.Exec(query, token.UserID); err != nil {
+		return err
+	}
+
+	token.ID = uuid.New()
+	query = `INSERT INTO email_verification_tokens (id, user_id, token_hash, expires_at)
+             VALUES ($1, $2, $3, $4)
+             RETURNING created_at`
+	err = tx.QueryRow(query, token.ID, token.UserID, token.TokenHash, token.ExpiresAt).Scan(&token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create email verification token: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetLastEmailVerificationSentAt returns when the newest verification token
+// for the user was created, or nil if none was ever sent.
+func (r *AuthRepository) GetLastEmailVerificationSentAt(userID uuid.UUID) (*time.Time, error) {
+	var sentAt *time.Time
+	query := `SELECT MAX(created_at) FROM email_verification_tokens WHERE user_id = $1`
+	if err := r.db.QueryRow(query, userID).Scan(&sentAt); err != nil {
+		return nil, err
+	}
+	return sentAt, nil
+}
+
+// VerifyEmail consumes an unused, unexpired verification token and marks
+// its user's email as verified. It returns the user ID, or sql.ErrNoRows if
+// the token is unknown, used or expired.
+func (r *AuthRepository) VerifyEmail(tokenHash string) (uuid.UUID, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var userID uuid.UUID
+	query := `UPDATE email_verification_tokens SET used_at = NOW()
+              WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+              RETURNING user_id`
+	if err = tx.QueryRow(query, tokenHash).Scan(&userID); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to consume email verification token: %w", err)
+	}
+
+	query = `UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW() WHERE id = $1`
+	if _, err = tx.Exec(query, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, tx.Commit()
+}
+
 func (r *AuthRepository) GetSessionByToken(token string) (*models.AuthSession, error) {
 	var session models.AuthSession
-	query := `SELECT id, user_id, token, expires_at, created_at, updated_at 
+	query := `SELECT id, user_id, token, family_id, device_name, user_agent, ip_address, last_seen_at,
+                     expires_at, created_at, updated_at 
               FROM auth_sessions 
               WHERE token = $1 AND expires_at > NOW()`
 
 	err := r.db.QueryRow(query, token).Scan(
-		&session.ID, &session.UserID, &session.Token,
+		&session.ID, &session.UserID, &session.Token, &session.FamilyID,
+		&session.DeviceName, &session.UserAgent, &session.IPAddress, &session.LastSeenAt,
 		&session.ExpiresAt, &session.CreatedAt, &session.UpdatedAt,
 	)
 
@@ -56,20 +298,160 @@ func (r *AuthRepository) GetSessionByToken(token string) (*models.AuthSession, e
 	return &session, nil
 }
 
-func (r *AuthRepository) DeleteUserSessions(userID uuid.UUID) error {
-	query := `DELETE FROM auth_sessions WHERE user_id = $1`
-	_, err := r.db.Exec(query, userID)
-	return err
+// DeleteUserSessions ends every session of the user except keepSessionID
+// (pass 0 to keep none) and revokes the refresh token families behind them.
+// It returns the number of sessions ended.
+func (r *AuthRepository) DeleteUserSessions(userID uuid.UUID, keepSessionID uint) (int64, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The kept session's family stays valid so its refresh token keeps working
+	query := `UPDATE refresh_tokens SET revoked_at = NOW()
+              WHERE user_id = $1 AND revoked_at IS NULL
+                AND family_id IS DISTINCT FROM (SELECT family_id FROM auth_sessions WHERE id = $2)`
+	if _, err = tx.Exec(query, userID, keepSessionID); err != nil {
+		return 0, err
+	}
+
+	query = `DELETE FROM auth_sessions WHERE user_id = $1 AND id <> $2`
+	result, err := tx.Exec(query, userID, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return rowsAffected, tx.Commit()
+}
+
+func (r *AuthRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+              VALUES ($1, $2, $3, $4, $5)
+              RETURNING created_at`
+
+	token.ID = uuid.New()
+	err := r.db.QueryRow(query, token.ID, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt).Scan(&token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	return nil
+}
+
+// GetRefreshTokenByHash returns the refresh token regardless of its state so
+// callers can tell a rotated token (reuse) apart from an unknown one.
+func (r *AuthRepository) GetRefreshTokenByHash(tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	query := `SELECT id, user_id, family_id, token_hash, expires_at, rotated_at, revoked_at, created_at
+              FROM refresh_tokens WHERE token_hash = $1`
+
+	if err := r.db.Get(&token, query, tokenHash); err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken marks the current refresh token as used and issues its
+// successor plus a fresh access session in the same family. Access sessions
+// issued from earlier tokens in the family are dropped.
+func (r *AuthRepository) RotateRefreshToken(currentID uuid.UUID, next *models.RefreshToken, session *models.AuthSession) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Guarded update so two concurrent exchanges of the same token can't both win
+	query := `UPDATE refresh_tokens SET rotated_at = NOW()
+              WHERE id = $1 AND rotated_at IS NULL AND revoked_at IS NULL`
+	result, err := tx.Exec(query, currentID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	query = `DELETE FROM auth_sessions WHERE family_id = $1`
+	if _, err = tx.Exec(query, next.FamilyID); err != nil {
+		return err
+	}
+
+	next.ID = uuid.New()
+	query = `INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+             VALUES ($1, $2, $3, $4, $5)
+             RETURNING created_at`
+	err = tx.QueryRow(query, next.ID, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt).Scan(&next.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	if err = insertSession(tx, session); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeRefreshFamily revokes every refresh token in the family and ends all
+// access sessions issued from it.
+func (r *AuthRepository) RevokeRefreshFamily(familyID uuid.UUID) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+	if _, err = tx.Exec(query, familyID); err != nil {
+		return err
+	}
+
+	query = `DELETE FROM auth_sessions WHERE family_id = $1`
+	if _, err = tx.Exec(query, familyID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (r *AuthRepository) GetUserByEmail(email string) (*models.User, error) {
 	var user models.User
-	query := `SELECT id, username, email, full_name, profile_picture_url, bio, age, city, country, 
+	query := `SELECT id, username, email, email_verified_at, role, suspended_at, suspension_reason, full_name, profile_picture_url, bio, age, city, country, 
               interests, latitude, longitude, location_updated_at, total_interactions, 
               average_rating, created_at, updated_at FROM users WHERE email = $1`
 
 	err := r.db.QueryRow(query, email).Scan(
-		&user.ID, &user.Username, &user.Email, &user.FullName, &user.ProfilePictureURL,
+		&user.ID, &user.Username, &user.Email, &user.EmailVerifiedAt,
+		&user.Role, &user.SuspendedAt, &user.SuspensionReason, &user.FullName, &user.ProfilePictureURL,
+		&user.Bio, &user.Age, &user.City, &user.Country, &user.Interests,
+		&user.Latitude, &user.Longitude, &user.LocationUpdatedAt,
+		&user.TotalInteractions, &user.AverageRating, &user.CreatedAt, &user.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	return &user, nil
+}
+
+func (r *AuthRepository) GetUserByID(id uuid.UUID) (*models.User, error) {
+	var user models.User
+	query := `SELECT id, username, email, email_verified_at, role, suspended_at, suspension_reason, full_name, profile_picture_url, bio, age, city, country, 
+              interests, latitude, longitude, location_updated_at, total_interactions, 
+              average_rating, created_at, updated_at FROM users WHERE id = $1`
+
+	err := r.db.QueryRow(query, id).Scan(
+		&user.ID, &user.Username, &user.Email, &user.EmailVerifiedAt,
+		&user.Role, &user.SuspendedAt, &user.SuspensionReason, &user.FullName, &user.ProfilePictureURL,
 		&user.Bio, &user.Age, &user.City, &user.Country, &user.Interests,
 		&user.Latitude, &user.Longitude, &user.LocationUpdatedAt,
 		&user.TotalInteractions, &user.AverageRating, &user.CreatedAt, &user.UpdatedAt,
@@ -87,12 +469,12 @@ func (r *AuthRepository) CreateUserWithPassword(user *models.User, passwordHash
 
 	query := `INSERT INTO users (id, username, email, password_hash, full_name, bio, age, city, country, interests) 
               VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) 
-              RETURNING created_at, updated_at`
+              RETURNING role, created_at, updated_at`
 
 	err := r.db.QueryRow(query,
 		user.ID, user.Username, user.Email, passwordHash, user.FullName,
 		user.Bio, user.Age, user.City, user.Country, user.Interests,
-	).Scan(&user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.Role, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
@@ -112,12 +494,13 @@ func (r *AuthRepository) EmailExists(email string) (bool, error) {
 
 func (r *AuthRepository) GetUserByUsername(username string) (*models.User, error) {
 	var user models.User
-	query := `SELECT id, username, email, full_name, profile_picture_url, bio, age, city, country, 
+	query := `SELECT id, username, email, email_verified_at, role, suspended_at, suspension_reason, full_name, profile_picture_url, bio, age, city, country, 
               interests, latitude, longitude, location_updated_at, total_interactions, 
               average_rating, created_at, updated_at FROM users WHERE username = $1`
 
 	err := r.db.QueryRow(query, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.FullName, &user.ProfilePictureURL,
+		&user.ID, &user.Username, &user.Email, &user.EmailVerifiedAt,
+		&user.Role, &user.SuspendedAt, &user.SuspensionReason, &user.FullName, &user.ProfilePictureURL,
 		&user.Bio, &user.Age, &user.City, &user.Country, &user.Interests,
 		&user.Latitude, &user.Longitude, &user.LocationUpdatedAt,
 		&user.TotalInteractions, &user.AverageRating, &user.CreatedAt, &user.UpdatedAt,
@@ -128,3 +511,187 @@ func (r *AuthRepository) GetUserByUsername(username string) (*models.User, error
 	}
 	return &user, nil
 }
+
+// GetTOTP returns the user's TOTP enrollment, confirmed or pending
+func (r *AuthRepository) GetTOTP(userID uuid.UUID) (*models.UserTOTP, error) {
+	var totp models.UserTOTP
+	query := `SELECT user_id, secret, enabled_at, last_used_step, created_at FROM user_totp WHERE user_id = $1`
+
+	if err := r.db.Get(&totp, query, userID); err != nil {
+		return nil, fmt.Errorf("failed to get totp: %w", err)
+	}
+	return &totp, nil
+}
+
+func (r *AuthRepository) IsTOTPEnabled(userID uuid.UUID) (bool, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM user_totp WHERE user_id = $1 AND enabled_at IS NOT NULL`
+	err := r.db.Get(&count, query, userID)
+	return count > 0, err
+}
+
+// SaveTOTPSecret starts (or restarts) a pending enrollment. A confirmed
+// enrollment is left untouched and false is returned.
+func (r *AuthRepository) SaveTOTPSecret(userID uuid.UUID, secret string) (bool, error) {
+	query := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+              ON CONFLICT (user_id) DO UPDATE
+              SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+              WHERE user_totp.enabled_at IS NULL`
+
+	result, err := r.db.Exec(query, userID, secret)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// EnableTOTP confirms the pending enrollment and replaces the user's
+// recovery codes with the given hashes.
+func (r *AuthRepository) EnableTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE user_totp SET enabled_at = NOW(), last_used_step = $2
+              WHERE user_id = $1 AND enabled_at IS NULL`
+	result, err := tx.Exec(query, userID, step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no pending totp enrollment: %w", sql.ErrNoRows)
+	}
+
+	query = `DELETE FROM totp_recovery_codes WHERE user_id = $1`
+	if _, err = tx.Exec(query, userID); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO totp_recovery_codes (id, user_id, code_hash) VALUES ($1, $2, $3)`
+	for _, codeHash := range recoveryCodeHashes {
+		if _, err = tx.Exec(query, uuid.New(), userID, codeHash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP removes the enrollment and all recovery codes
+func (r *AuthRepository) DisableTOTP(userID uuid.UUID) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records step as used. It returns false if the step (or a later
+// one) was already used, i.e. the code is being replayed.
+func (r *AuthRepository) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	query := `UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+	result, err := r.db.Exec(query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// UseRecoveryCode consumes an unused recovery code, reporting whether it matched
+func (r *AuthRepository) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	query := `UPDATE totp_recovery_codes SET used_at = NOW()
+              WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := r.db.Exec(query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (r *AuthRepository) CountUnusedRecoveryCodes(userID uuid.UUID) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM totp_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	err := r.db.Get(&count, query, userID)
+	return count, err
+}
+
+func (r *AuthRepository) CreateLoginChallenge(challenge *models.LoginChallenge) error {
+	query := `INSERT INTO login_challenges (id, user_id, token_hash, expires_at)
+              VALUES ($1, $2, $3, $4)
+              RETURNING created_at`
+
+	challenge.ID = uuid.New()
+	err := r.db.QueryRow(query, challenge.ID, challenge.UserID, challenge.TokenHash, challenge.ExpiresAt).Scan(&challenge.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create login challenge: %w", err)
+	}
+	return nil
+}
+
+// GetLoginChallengeByHash returns an unused, unexpired challenge
+func (r *AuthRepository) GetLoginChallengeByHash(tokenHash string) (*models.LoginChallenge, error) {
+	var challenge models.LoginChallenge
+	query := `SELECT id, user_id, token_hash, attempts, expires_at, used_at, created_at
+              FROM login_challenges
+              WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`
+
+	if err := r.db.Get(&challenge, query, tokenHash); err != nil {
+		return nil, fmt.Errorf("failed to get login challenge: %w", err)
+	}
+	return &challenge, nil
+}
+
+// FailLoginChallenge counts a wrong code against the challenge and burns it
+// once maxAttempts is reached. It returns the attempts made so far.
+func (r *AuthRepository) FailLoginChallenge(id uuid.UUID, maxAttempts int) (int, error) {
+	var attempts int
+	query := `UPDATE login_challenges
+              SET attempts = attempts + 1,
+                  used_at = CASE WHEN attempts + 1 >= $2 THEN NOW() ELSE used_at END
+              WHERE id = $1
+              RETURNING attempts`
+	err := r.db.QueryRow(query, id, maxAttempts).Scan(&attempts)
+	return attempts, err
+}
+
+// ConsumeLoginChallenge marks the challenge used, reporting false if another
+// request already completed it.
+func (r *AuthRepository) ConsumeLoginChallenge(id uuid.UUID) (bool, error) {
+	query := `UPDATE login_challenges SET used_at = NOW() WHERE id = $1 AND used_at IS NULL`
+	result, err := r.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,396 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"tukarkultur/api/models"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ErrOwnerMustTransfer is returned when a group's owner tries to leave while
+// others are still in it
+var ErrOwnerMustTransfer = errors.New("the owner must hand over the group before leaving")
+
+// UnknownUsersError is returned when participants are added who don't exist
+type UnknownUsersError struct {
+	UserIDs []uuid.UUID
+}
+
+func (e *UnknownUsersError) Error() string {
+	return fmt.Sprintf("unknown users: %s", strings.Join(uuidStrings(e.UserIDs), ", "))
+}
+
+type ConversationRepository struct {
+	db *sqlx.DB
+}
+
+func NewConversationRepository(db *sqlx.DB) *ConversationRepository {
+	return &ConversationRepository{db: db}
+}
+
+// EnsureDirect returns the direct conversation between a and b, creating it
+// on their first message.
+func (r *ConversationRepository) EnsureDirect(a, b uuid.UUID) (*models.Conversation, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id := models.DirectConversationID(a, b)
+	query := `
+        INSERT INTO conversations (id, type, created_at) VALUES ($1, $2, NOW())
+        ON CONFLICT (id) DO NOTHING`
+	if _, err := tx.Exec(query, id, models.ConversationTypeDirect); err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+
+	query = `
+        INSERT INTO conversation_participants (conversation_id, user_id, role, joined_at)
+        SELECT $1, id, $3, NOW() FROM users WHERE id = ANY($2)
+        ON CONFLICT (conversation_id, user_id) DO NOTHING`
+	if _, err := tx.Exec(query, id, pq.Array(uuidStrings([]uuid.UUID{a, b})), models.ParticipantRoleMember); err != nil {
+		return nil, fmt.Errorf("failed to add participants: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetByID(id)
+}
+
+// CreateGroup stores a group conversation owned by its creator, with
+// participantIDs as members. It returns an *UnknownUsersError if any of them
+// doesn't exist.
+func (r *ConversationRepository) CreateGroup(conversation *models.Conversation, participantIDs []uuid.UUID) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	conversation.ID = uuid.New()
+	conversation.Type = models.ConversationTypeGroup
+
+	query := `
+        INSERT INTO conversations (id, type, title, meetup_id, created_by, created_at)
+        VALUES ($1, $2, $3, $4, $5, NOW())
+        RETURNING created_at`
+	err = tx.QueryRow(
+		query,
+		conversation.ID,
+		conversation.Type,
+		conversation.Title,
+		conversation.MeetupID,
+		conversation.CreatedBy,
+	).Scan(&conversation.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create conversation: %w", err)
+	}
+
+	query = `
+        INSERT INTO conversation_participants (conversation_id, user_id, role, joined_at)
+        VALUES ($1, $2, $3, NOW())`
+	if _, err := tx.Exec(query, conversation.ID, *conversation.CreatedBy, models.ParticipantRoleOwner); err != nil {
+		return fmt.Errorf("failed to add owner: %w", err)
+	}
+
+	if _, err := addParticipants(tx, conversation.ID, participantIDs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	conversation.Participants, err = r.GetParticipants(conversation.ID)
+	return err
+}
+
+// GetByID returns the conversation with its participants
+func (r *ConversationRepository) GetByID(id uuid.UUID) (*models.Conversation, error) {
+	query := `
+        SELECT id, type, title, meetup_id, created_by, created_at
+        FROM conversations WHERE id = $1`
+
+	conversation := &models.Conversation{}
+	err := r.db.QueryRow(query, id).Scan(
+		&conversation.ID,
+		&conversation.Type,
+		&conversation.Title,
+		&conversation.MeetupID,
+		&conversation.CreatedBy,
+		&conversation.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	conversation.Participants, err = r.GetParticipants(id)
+	if err != nil {
+		return nil, err
+	}
+	return conversation, nil
+}
+
+// GetParticipants returns the members of a conversation, owners first
+func (r *ConversationRepository) GetParticipants(conversationID uuid.UUID) ([]*models.ConversationParticipant, error) {
+	query := `
+        SELECT user_id, role, joined_at, translate FROM conversation_participants
+        WHERE conversation_id = $1
+        ORDER BY role = 'owner' DESC, joined_at, user_id`
+
+	participants := []*models.ConversationParticipant{}
+	if err := r.db.Select(&participants, query, conversationID); err != nil {
+		return nil, fmt.Errorf("failed to get participants: %w", err)
+	}
+	return participants, nil
+}
+
+// AddParticipants adds the users among userIDs who aren't members yet, and
+// returns the IDs of those added. It returns an *UnknownUsersError, adding
+// nobody, if any of them doesn't exist.
+func (r *ConversationRepository) AddParticipants(conversationID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	added, err := addParticipants(tx, conversationID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	return added, tx.Commit()
+}
+
+func addParticipants(tx *sqlx.Tx, conversationID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	existing := []uuid.UUID{}
+	query := `SELECT id FROM users WHERE id = ANY($1)`
+	if err := tx.Select(&existing, query, pq.Array(uuidStrings(userIDs))); err != nil {
+		return nil, fmt.Errorf("failed to look up participants: %w", err)
+	}
+	var unknown []uuid.UUID
+	for _, id := range userIDs {
+		if !slices.Contains(existing, id) && !slices.Contains(unknown, id) {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, &UnknownUsersError{UserIDs: unknown}
+	}
+
+	query = `
+        INSERT INTO conversation_participants (conversation_id, user_id, role, joined_at)
+        SELECT $1, id, $3, NOW() FROM users WHERE id = ANY($2)
+        ON CONFLICT (conversation_id, user_id) DO NOTHING
+        RETURNING user_id`
+
+	added := []uuid.UUID{}
+	if err := tx.Select(&added, query, conversationID, pq.Array(uuidStrings(userIDs)), models.ParticipantRoleMember); err != nil {
+		return nil, fmt.Errorf("failed to add participants: %w", err)
+	}
+	return added, nil
+}
+
+// RemoveParticipant takes userID out of a group conversation. The owner can
+// only leave once nobody else is left, which deletes the conversation;
+// otherwise ErrOwnerMustTransfer is returned and they have to hand the
+// group over with TransferOwnership first.
+func (r *ConversationRepository) RemoveParticipant(conversationID, userID uuid.UUID) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the members so an owner leaving can't race a member joining
+	var role string
+	var members int
+	query := `
+        SELECT COALESCE(MAX(role) FILTER (WHERE user_id = $2), ''), COUNT(*)
+        FROM (
+            SELECT user_id, role FROM conversation_participants
+            WHERE conversation_id = $1
+            FOR UPDATE
+        ) participants`
+	if err := tx.QueryRow(query, conversationID, userID).Scan(&role, &members); err != nil {
+		return fmt.Errorf("failed to count participants: %w", err)
+	}
+	if role == "" {
+		return sql.ErrNoRows
+	}
+	if role == models.ParticipantRoleOwner && members > 1 {
+		return ErrOwnerMustTransfer
+	}
+
+	query = `DELETE FROM conversation_participants WHERE conversation_id = $1 AND user_id = $2`
+	if _, err := tx.Exec(query, conversationID, userID); err != nil {
+		return fmt.Errorf("failed to remove participant: %w", err)
+	}
+	if members == 1 {
+		if _, err := tx.Exec(`DELETE FROM conversations WHERE id = $1`, conversationID); err != nil {
+			return fmt.Errorf("failed to delete conversation: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TransferOwnership makes newOwnerID the owner of a group conversation in
+// place of ownerID, who stays on as a member. It returns sql.ErrNoRows if
+// newOwnerID isn't a participant.
+func (r *ConversationRepository) TransferOwnership(conversationID, ownerID, newOwnerID uuid.UUID) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE conversation_participants SET role = $3 WHERE conversation_id = $1 AND user_id = $2`
+	result, err := tx.Exec(query, conversationID, newOwnerID, models.ParticipantRoleOwner)
+	if err != nil {
+		return fmt.Errorf("failed to transfer ownership: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(query, conversationID, ownerID, models.ParticipantRoleMember); err != nil {
+		return fmt.Errorf("failed to transfer ownership: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *ConversationRepository) UpdateTitle(id uuid.UUID, title *string) error {
+	_, err := r.db.Exec(`UPDATE conversations SET title = $2 WHERE id = $1`, id, title)
+	return err
+}
+
+// SetTranslate turns translation of incoming messages on or off for userID
+// in a conversation. It returns sql.ErrNoRows if they aren't a participant.
+func (r *ConversationRepository) SetTranslate(conversationID, userID uuid.UUID, enabled bool) error {
+	query := `UPDATE conversation_participants SET translate = $3 WHERE conversation_id = $1 AND user_id = $2`
+	result, err := r.db.Exec(query, conversationID, userID, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to update translation: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetTranslationLanguages returns the preferred language of each participant
+// who turned translation on in a conversation and picked one
+func (r *ConversationRepository) GetTranslationLanguages(conversationID uuid.UUID) (map[uuid.UUID]string, error) {
+	query := `
+        SELECT p.user_id, s.preferred_language
+        FROM conversation_participants p
+        JOIN user_settings s ON s.user_id = p.user_id
+        WHERE p.conversation_id = $1 AND p.translate AND s.preferred_language IS NOT NULL`
+
+	rows, err := r.db.Query(query, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get translation languages: %w", err)
+	}
+	defer rows.Close()
+
+	languages := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var userID uuid.UUID
+		var language string
+		if err := rows.Scan(&userID, &language); err != nil {
+			return nil, fmt.Errorf("failed to scan translation language: %w", err)
+		}
+		languages[userID] = language
+	}
+	return languages, rows.Err()
+}
+
+// GetForUser returns userID's conversations with their unread count and
+// the other user of direct conversations, most recently active first. The
+// last message is the last one userID got, as group members don't see what
+// was sent before they joined, leaving out those they deleted for
+// themselves. Only its ID is set, see MessageRepository.GetByIDs.
+func (r *ConversationRepository) GetForUser(userID uuid.UUID) ([]*models.Conversation, error) {
+	query := `
+        SELECT c.id, c.type, c.title, c.meetup_id, c.created_by, c.created_at,
+               peer.user_id, last.id,
+               (SELECT COUNT(*)
+                FROM message_deliveries ud
+                JOIN messages um ON um.id = ud.message_id
+                WHERE ud.user_id = $1 AND ud.read_at IS NULL
+                  AND um.conversation_id = c.id AND um.sender_id <> $1
+                  AND um.deleted_at IS NULL
+                  AND NOT EXISTS (
+                      SELECT 1 FROM message_hidden uh
+                      WHERE uh.message_id = um.id AND uh.user_id = $1)) AS unread_count
+        FROM conversation_participants p
+        JOIN conversations c ON c.id = p.conversation_id
+        LEFT JOIN LATERAL (
+            SELECT user_id FROM conversation_participants
+            WHERE c.type = 'direct' AND conversation_id = c.id
+            ORDER BY user_id = $1
+            LIMIT 1
+        ) peer ON TRUE
+        LEFT JOIN LATERAL (
+            SELECT lm.id, lm.created_at FROM messages lm
+            WHERE lm.conversation_id = c.id
+              AND (lm.sender_id = $1 OR lm.receiver_id = $1 OR EXISTS (
+                  SELECT 1 FROM message_deliveries ld
+                  WHERE ld.message_id = lm.id AND ld.user_id = $1))
+              AND NOT EXISTS (
+                  SELECT 1 FROM message_hidden lh
+                  WHERE lh.message_id = lm.id AND lh.user_id = $1)
+            ORDER BY lm.created_at DESC, lm.id DESC
+            LIMIT 1
+        ) last ON TRUE
+        WHERE p.user_id = $1
+        ORDER BY COALESCE(last.created_at, c.created_at) DESC`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversations: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := []*models.Conversation{}
+	for rows.Next() {
+		conversation := &models.Conversation{}
+		err := rows.Scan(
+			&conversation.ID,
+			&conversation.Type,
+			&conversation.Title,
+			&conversation.MeetupID,
+			&conversation.CreatedBy,
+			&conversation.CreatedAt,
+			&conversation.PeerID,
+			&conversation.LastMessageID,
+			&conversation.UnreadCount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, rows.Err()
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = id.String()
+	}
+	return values
+}
@@ -49,6 +49,18 @@ func (r *FriendRepository) AreFriends(userID1, userID2 uuid.UUID) (bool, error)
 	return count > 0, err
 }
 
+// GetFriendIDs returns the IDs of userID's friends
+func (r *FriendRepository) GetFriendIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	query := `
+        SELECT DISTINCT CASE WHEN user_id_1 = $1 THEN user_id_2 ELSE user_id_1 END
+        FROM friends
+        WHERE user_id_1 = $1 OR user_id_2 = $1
+    `
+	err := r.db.Select(&ids, query, userID)
+	return ids, err
+}
+
 // New friend request methods
 func (r *FriendRepository) CreateFriendRequest(friendRequest *models.FriendRequest) error {
 	query := `
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"tukarkultur/api/models"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// LoginAttemptRepository keeps login throttling state and the login audit
+// trail in Postgres so every API instance sees the same counters.
+type LoginAttemptRepository struct {
+	db *sqlx.DB
+}
+
+func NewLoginAttemptRepository(db *sqlx.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+// RecordAttempt appends a login attempt to the audit trail
+func (r *LoginAttemptRepository) RecordAttempt(attempt *models.LoginAttempt) error {
+	query := `INSERT INTO login_attempts (email, user_id, ip_address, success, reason)
+              VALUES ($1, $2, $3, $4, $5)
+              RETURNING id, created_at`
+
+	err := r.db.QueryRow(query, attempt.Email, attempt.UserID, attempt.IPAddress, attempt.Success, attempt.Reason).Scan(
+		&attempt.ID, &attempt.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record login attempt: %w", err)
+	}
+	return nil
+}
+
+// Acquire checks the throttle keys and counts the attempt as a failure on
+// each of them in one transaction, before the credentials are checked, so
+// parallel attempts can't all pass the check before the first failure is
+// recorded. backoffs gives how long each key is locked after its nth
+// consecutive failure. A streak restarts at 1 once window has passed since
+// its last failure and since the lock it caused ran out, so a lockout longer
+// than window still escalates. If any key is locked nothing is counted and
+// the latest lock expiry is returned; otherwise it returns nil.
+func (r *LoginAttemptRepository) Acquire(window time.Duration, backoffs map[string]func(failures int) time.Duration) (*time.Time, error) {
+	keys := make([]string, 0, len(backoffs))
+	for key := range backoffs {
+		keys = append(keys, key)
+	}
+	// One order for every transaction so two logins can't deadlock
+	sort.Strings(keys)
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		query := `INSERT INTO login_throttles (key) VALUES ($1) ON CONFLICT (key) DO NOTHING`
+		if _, err := tx.Exec(query, key); err != nil {
+			return nil, fmt.Errorf("failed to create login throttle: %w", err)
+		}
+	}
+
+	query := `SELECT key, failures, last_failure_at, locked_until, NOW()
+              FROM login_throttles
+              WHERE key = ANY($1)
+              ORDER BY key
+              FOR UPDATE`
+	rows, err := tx.Query(query, pq.Array(keys))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock login throttles: %w", err)
+	}
+	defer rows.Close()
+
+	type throttle struct {
+		key                        string
+		failures                   int
+		lastFailureAt, lockedUntil *time.Time
+	}
+	var throttles []throttle
+	var now time.Time
+	var lockedUntil *time.Time
+	for rows.Next() {
+		var t throttle
+		if err := rows.Scan(&t.key, &t.failures, &t.lastFailureAt, &t.lockedUntil, &now); err != nil {
+			return nil, fmt.Errorf("failed to scan login throttle: %w", err)
+		}
+		if t.lockedUntil != nil && t.lockedUntil.After(now) && (lockedUntil == nil || t.lockedUntil.After(*lockedUntil)) {
+			lockedUntil = t.lockedUntil
+		}
+		throttles = append(throttles, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if lockedUntil != nil {
+		return lockedUntil, nil
+	}
+
+	for _, t := range throttles {
+		last := t.lastFailureAt
+		if t.lockedUntil != nil && (last == nil || t.lockedUntil.After(*last)) {
+			last = t.lockedUntil
+		}
+		failures := t.failures + 1
+		if last == nil || last.Before(now.Add(-window)) {
+			failures = 1
+		}
+
+		query := `UPDATE login_throttles SET failures = $2, last_failure_at = $3, locked_until = $4 WHERE key = $1`
+		if _, err := tx.Exec(query, t.key, failures, now, now.Add(backoffs[t.key](failures))); err != nil {
+			return nil, fmt.Errorf("failed to record login failure: %w", err)
+		}
+	}
+
+	return nil, tx.Commit()
+}
+
+// Forgive takes back one failure counted against key, without lifting its lock
+func (r *LoginAttemptRepository) Forgive(key string) error {
+	query := `UPDATE login_throttles SET failures = GREATEST(failures - 1, 0) WHERE key = $1`
+	_, err := r.db.Exec(query, key)
+	return err
+}
+
+// Reset clears the failure streak and any lock for key
+func (r *LoginAttemptRepository) Reset(key string) error {
+	query := `DELETE FROM login_throttles WHERE key = $1`
+	_, err := r.db.Exec(query, key)
+	return err
+}
+
+// GetFailedAttemptsForUser lists recent failed logins against the user's account
+func (r *LoginAttemptRepository) GetFailedAttemptsForUser(userID uuid.UUID, limit int) ([]*models.LoginAttempt, error) {
+	var attempts []*models.LoginAttempt
+	query := `SELECT id, email, user_id, ip_address, success, reason, created_at
+              FROM login_attempts
+              WHERE user_id = $1 AND success = false
+              ORDER BY created_at DESC
+              LIMIT $2`
+
+	err := r.db.Select(&attempts, query, userID, limit)
+	return attempts, err
+}
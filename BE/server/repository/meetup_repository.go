@@ -207,6 +207,32 @@ func (r *MeetupRepository) Update(meetup *models.Meetup) error {
 	return nil
 }
 
+// Confirm confirms a meetup that is still proposed to userID, or to anyone,
+// recording userID as its invitee. It returns sql.ErrNoRows if the meetup
+// isn't, e.g. because someone else accepted or cancelled it meanwhile.
+func (r *MeetupRepository) Confirm(id, userID uuid.UUID) (*models.Meetup, error) {
+	query := `
+        UPDATE meetups SET status = 'confirmed', proposed_to = COALESCE(proposed_to, $2)
+        WHERE id = $1 AND status = 'proposed' AND (proposed_to IS NULL OR proposed_to = $2)
+        RETURNING id, proposed_by, proposed_to, location_name, location_address, meetup_time, status, created_at`
+
+	meetup := &models.Meetup{}
+	err := r.db.QueryRow(query, id, userID).Scan(
+		&meetup.ID,
+		&meetup.ProposedBy,
+		&meetup.ProposedTo,
+		&meetup.LocationName,
+		&meetup.LocationAddress,
+		&meetup.MeetupTime,
+		&meetup.Status,
+		&meetup.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return meetup, nil
+}
+
 func (r *MeetupRepository) Delete(id uuid.UUID) error {
 	query := `DELETE FROM meetups WHERE id = $1`
 	_, err := r.db.Exec(query, id)
@@ -0,0 +1,755 @@
+package repository
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"tukarkultur/api/models"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type MessageRepository struct {
+	db *sqlx.DB
+}
+
+func NewMessageRepository(db *sqlx.DB) *MessageRepository {
+	return &MessageRepository{db: db}
+}
+
+// messageColumns selects messages m along with their sequence number in the
+// viewing user's inbox, from that user's message_deliveries row d, the
+// receiver's delivery state, the proposed meetup and the viewing user's
+// translation from messageJoins. read_at is only shown to the receiver
+// themselves unless they allow read receipts. Reactions are loaded
+// separately, see attachReactions.
+const messageColumns = `m.id, m.conversation_id, m.sender_id, m.receiver_id, m.message_text, m.message_type,
+               m.image_url, m.cloudinary_public_id, m.created_at, COALESCE(d.seq, 0), r.delivered_at AS delivered_at,
+               CASE WHEN COALESCE(rs.read_receipts, TRUE) OR d.user_id = m.receiver_id THEN r.read_at END AS read_at,
+               m.latitude, m.longitude, m.location_name, m.meetup_id,
+               mt.proposed_by AS meetup_proposed_by, mt.proposed_to AS meetup_proposed_to,
+               mt.location_name AS meetup_location_name, mt.location_address AS meetup_location_address,
+               mt.meetup_time AS meetup_time, mt.status AS meetup_status, mt.created_at AS meetup_created_at,
+               m.edited_at, m.deleted_at, tr.language, tr.translated_text`
+
+const messageJoins = `
+        LEFT JOIN message_deliveries r ON r.message_id = m.id AND r.user_id = m.receiver_id
+        LEFT JOIN user_settings rs ON rs.user_id = m.receiver_id
+        LEFT JOIN meetups mt ON mt.id = m.meetup_id
+        LEFT JOIN conversation_participants vp ON vp.conversation_id = m.conversation_id
+            AND vp.user_id = d.user_id AND vp.translate AND m.sender_id <> d.user_id
+        LEFT JOIN user_settings vs ON vs.user_id = vp.user_id
+        LEFT JOIN message_translations tr ON tr.message_id = m.id AND tr.language = vs.preferred_language`
+
+// notHidden filters out the messages the user in the given parameter
+// deleted for themselves
+func notHidden(userParam string) string {
+	return `NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id = ` + userParam + `)`
+}
+
+type messageScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row messageScanner) (*models.Message, error) {
+	message := &models.Message{}
+	var latitude, longitude sql.NullFloat64
+	var locationName *string
+	var meetup struct {
+		ProposedBy      *uuid.UUID
+		ProposedTo      *uuid.UUID
+		LocationName    *string
+		LocationAddress *string
+		MeetupTime      *time.Time
+		Status          *string
+		CreatedAt       *time.Time
+	}
+	var translationLanguage, translatedText *string
+	err := row.Scan(
+		&message.ID,
+		&message.ConversationID,
+		&message.SenderID,
+		&message.ReceiverID,
+		&message.MessageText,
+		&message.MessageType,
+		&message.ImageURL,
+		&message.CloudinaryPublicID,
+		&message.CreatedAt,
+		&message.Seq,
+		&message.DeliveredAt,
+		&message.ReadAt,
+		&latitude,
+		&longitude,
+		&locationName,
+		&message.MeetupID,
+		&meetup.ProposedBy,
+		&meetup.ProposedTo,
+		&meetup.LocationName,
+		&meetup.LocationAddress,
+		&meetup.MeetupTime,
+		&meetup.Status,
+		&meetup.CreatedAt,
+		&message.EditedAt,
+		&message.DeletedAt,
+		&translationLanguage,
+		&translatedText,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if latitude.Valid && longitude.Valid {
+		message.Location = &models.MessageLocation{
+			Latitude:  latitude.Float64,
+			Longitude: longitude.Float64,
+			Name:      locationName,
+		}
+	}
+	// The meetup may have been deleted since it was proposed
+	if message.MeetupID != nil && meetup.ProposedBy != nil {
+		message.Meetup = &models.Meetup{
+			ID:              *message.MeetupID,
+			ProposedBy:      *meetup.ProposedBy,
+			ProposedTo:      meetup.ProposedTo,
+			LocationName:    meetup.LocationName,
+			LocationAddress: meetup.LocationAddress,
+			MeetupTime:      meetup.MeetupTime,
+		}
+		if meetup.Status != nil {
+			message.Meetup.Status = *meetup.Status
+		}
+		if meetup.CreatedAt != nil {
+			message.Meetup.CreatedAt = *meetup.CreatedAt
+		}
+	}
+	if translationLanguage != nil && translatedText != nil {
+		message.Translation = &models.MessageTranslation{Language: *translationLanguage, Text: *translatedText}
+	}
+	return message, nil
+}
+
+// Create stores message in its conversation, filling in its ID and
+// timestamp, and appends it to the inbox of the sender and every recipient.
+// It returns the sequence number the message got in each inbox.
+func (r *MessageRepository) Create(message *models.Message, recipientIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO messages (id, conversation_id, sender_id, receiver_id, message_text, message_type,
+                              image_url, cloudinary_public_id, latitude, longitude, location_name, meetup_id, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW())
+        RETURNING created_at`
+
+	message.ID = uuid.New()
+	if message.MessageType == "" {
+		message.MessageType = models.MessageTypeText
+	}
+
+	var latitude, longitude, locationName interface{}
+	if message.Location != nil {
+		latitude, longitude, locationName = message.Location.Latitude, message.Location.Longitude, message.Location.Name
+	}
+
+	err = tx.QueryRow(
+		query,
+		message.ID,
+		message.ConversationID,
+		message.SenderID,
+		message.ReceiverID,
+		message.MessageText,
+		message.MessageType,
+		message.ImageURL,
+		message.CloudinaryPublicID,
+		latitude,
+		longitude,
+		locationName,
+		message.MeetupID,
+	).Scan(&message.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message: %w", err)
+	}
+
+	// Bump the counters in a fixed order so two users messaging each other
+	// at the same time can't deadlock on them
+	recipients := []uuid.UUID{message.SenderID}
+	for _, userID := range recipientIDs {
+		if !slices.Contains(recipients, userID) {
+			recipients = append(recipients, userID)
+		}
+	}
+	sort.Slice(recipients, func(i, j int) bool {
+		return bytes.Compare(recipients[i][:], recipients[j][:]) < 0
+	})
+
+	seqs := make(map[uuid.UUID]int64, len(recipients))
+	for _, userID := range recipients {
+		seq, err := nextSeq(tx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The sender already has their own message
+		var deliveredAt interface{}
+		if userID == message.SenderID {
+			deliveredAt = message.CreatedAt
+		}
+
+		query = `INSERT INTO message_deliveries (user_id, seq, message_id, delivered_at) VALUES ($1, $2, $3, $4)`
+		if _, err := tx.Exec(query, userID, seq, message.ID, deliveredAt); err != nil {
+			return nil, fmt.Errorf("failed to queue message: %w", err)
+		}
+		seqs[userID] = seq
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+// nextSeq hands out the next inbox sequence number of userID, locking the
+// counter until the transaction ends so numbers commit in order
+func nextSeq(tx *sqlx.Tx, userID uuid.UUID) (int64, error) {
+	query := `
+        INSERT INTO user_message_sequences (user_id, last_seq) VALUES ($1, 1)
+        ON CONFLICT (user_id) DO UPDATE SET last_seq = user_message_sequences.last_seq + 1
+        RETURNING last_seq`
+
+	var seq int64
+	if err := tx.QueryRow(query, userID).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to assign sequence: %w", err)
+	}
+	return seq, nil
+}
+
+// GetInboxMessages returns up to limit messages from userID's inbox with a
+// sequence number above afterSeq, oldest first. With undeliveredOnly it
+// skips messages already written to one of the user's sockets.
+func (r *MessageRepository) GetInboxMessages(userID uuid.UUID, afterSeq int64, undeliveredOnly bool, limit int) ([]*models.Message, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM message_deliveries d
+        JOIN messages m ON m.id = d.message_id` + messageJoins + `
+        WHERE d.user_id = $1 AND d.seq > $2
+          AND (NOT $3 OR d.delivered_at IS NULL)
+          AND ` + notHidden("$1") + `
+        ORDER BY d.seq
+        LIMIT $4`
+
+	rows, err := r.db.Query(query, userID, afterSeq, undeliveredOnly, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inbox: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []*models.Message{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, r.attachReactions(messages)
+}
+
+// MarkDelivered records that the message at seq reached one of userID's
+// sockets. It returns the receipt for the sender, or nil if the message had
+// already been delivered.
+func (r *MessageRepository) MarkDelivered(userID uuid.UUID, seq int64) (*models.MessageReceipt, error) {
+	query := `
+        UPDATE message_deliveries d SET delivered_at = NOW()
+        FROM messages m
+        WHERE d.user_id = $1 AND d.seq = $2 AND d.delivered_at IS NULL AND m.id = d.message_id
+        RETURNING m.id, m.conversation_id, m.sender_id, d.user_id, d.delivered_at`
+
+	receipt := &models.MessageReceipt{}
+	err := r.db.QueryRow(query, userID, seq).Scan(
+		&receipt.MessageID, &receipt.ConversationID, &receipt.SenderID, &receipt.ReceiverID, &receipt.At,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark message delivered: %w", err)
+	}
+	return receipt, nil
+}
+
+// MarkRead marks messageID and every earlier message userID received in the
+// same conversation as read (and delivered, if they weren't yet). It returns
+// a receipt for the newest of them per sender, none if they were all read
+// already.
+func (r *MessageRepository) MarkRead(userID, messageID uuid.UUID) ([]*models.MessageReceipt, error) {
+	query := `
+        WITH target AS (
+            SELECT m.id, m.conversation_id, m.created_at
+            FROM messages m
+            JOIN message_deliveries d ON d.message_id = m.id AND d.user_id = $1
+            WHERE m.id = $2
+        ), marked AS (
+            UPDATE message_deliveries d SET read_at = NOW(), delivered_at = COALESCE(d.delivered_at, NOW())
+            FROM messages m, target t
+            WHERE d.user_id = $1 AND d.message_id = m.id AND d.read_at IS NULL
+              AND m.conversation_id = t.conversation_id AND m.sender_id <> $1
+              AND (m.created_at, m.id) <= (t.created_at, t.id)
+            RETURNING m.id, m.conversation_id, m.sender_id, d.user_id, m.created_at, d.read_at
+        )
+        SELECT DISTINCT ON (sender_id) id, conversation_id, sender_id, user_id, read_at
+        FROM marked
+        ORDER BY sender_id, created_at DESC, id DESC`
+
+	rows, err := r.db.Query(query, userID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark messages read: %w", err)
+	}
+	defer rows.Close()
+
+	receipts := []*models.MessageReceipt{}
+	for rows.Next() {
+		receipt := &models.MessageReceipt{}
+		if err := rows.Scan(&receipt.MessageID, &receipt.ConversationID, &receipt.SenderID, &receipt.ReceiverID, &receipt.At); err != nil {
+			return nil, fmt.Errorf("failed to scan receipt: %w", err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+// GetConversationMessages returns up to limit messages of a conversation
+// that reached userID's inbox (or, from before inboxes existed, that userID
+// sent or received) and that they didn't delete for themselves, newest
+// first, starting after before when given.
+func (r *MessageRepository) GetConversationMessages(conversationID, userID uuid.UUID, before *models.MessageCursor, limit int) ([]*models.Message, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages m
+        LEFT JOIN message_deliveries d ON d.message_id = m.id AND d.user_id = $2` + messageJoins + `
+        WHERE m.conversation_id = $1
+          AND (d.user_id IS NOT NULL OR m.sender_id = $2 OR m.receiver_id = $2)
+          AND ` + notHidden("$2") + `
+          AND ($3::timestamptz IS NULL OR (m.created_at, m.id) < ($3, $4))
+        ORDER BY m.created_at DESC, m.id DESC
+        LIMIT $5`
+
+	var beforeTime sql.NullTime
+	var beforeID uuid.UUID
+	if before != nil {
+		beforeTime = sql.NullTime{Time: before.CreatedAt, Valid: true}
+		beforeID = before.ID
+	}
+
+	rows, err := r.db.Query(query, conversationID, userID, beforeTime, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []*models.Message{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, r.attachReactions(messages)
+}
+
+// GetByIDs returns the given messages as seen by userID, leaving out those
+// they deleted for themselves
+func (r *MessageRepository) GetByIDs(ids []uuid.UUID, userID uuid.UUID) ([]*models.Message, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages m
+        LEFT JOIN message_deliveries d ON d.message_id = m.id AND d.user_id = $2` + messageJoins + `
+        WHERE m.id = ANY($1) AND ` + notHidden("$2")
+
+	rows, err := r.db.Query(query, pq.Array(uuidStrings(ids)), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []*models.Message{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, r.attachReactions(messages)
+}
+
+// Search snippets come out of ts_headline with the matches between these
+// private use characters, which are stripped from the text first, and are
+// only turned into <mark> tags once the text is HTML escaped, see
+// formatSnippet.
+const (
+	snippetMarkStart = "\uE000"
+	snippetMarkStop  = "\uE001"
+)
+
+// searchSnippetOptions shape the ts_headline snippets of search results
+const searchSnippetOptions = `StartSel="` + snippetMarkStart + `", StopSel="` + snippetMarkStop + `", MaxFragments=2, MaxWords=20, MinWords=5, FragmentDelimiter=" … "`
+
+// Search returns up to limit messages matching query among those userID can
+// see in their conversations, optionally just conversationID, newest first
+// and starting after before when given. The query is matched as English,
+// Indonesian and Japanese, see migration 0015_chat_search.
+func (r *MessageRepository) Search(userID uuid.UUID, query string, conversationID *uuid.UUID, before *models.MessageCursor, limit int) ([]*models.MessageSearchResult, error) {
+	sqlQuery := `
+        WITH q AS (
+            SELECT websearch_to_tsquery('english', $2) || websearch_to_tsquery('indonesian', $2) ||
+                   array_to_string(ARRAY(SELECT quote_literal(t) FROM unnest(chat_cjk_terms($2, TRUE)) AS t), ' & ')::tsquery AS query
+        )
+        SELECT ` + messageColumns + `,
+               ts_headline('english', translate(COALESCE(m.message_text, ''), '` + snippetMarkStart + snippetMarkStop + `', ''), q.query, '` + searchSnippetOptions + `')
+        FROM q, messages m
+        JOIN conversation_participants p ON p.conversation_id = m.conversation_id AND p.user_id = $1
+        LEFT JOIN message_deliveries d ON d.message_id = m.id AND d.user_id = $1` + messageJoins + `
+        WHERE m.search_vector @@ q.query
+          AND m.deleted_at IS NULL
+          AND (d.user_id IS NOT NULL OR m.sender_id = $1 OR m.receiver_id = $1)
+          AND ` + notHidden("$1") + `
+          AND ($3::uuid IS NULL OR m.conversation_id = $3)
+          AND ($4::timestamptz IS NULL OR (m.created_at, m.id) < ($4, $5))
+        ORDER BY m.created_at DESC, m.id DESC
+        LIMIT $6`
+
+	var beforeTime sql.NullTime
+	var beforeID uuid.UUID
+	if before != nil {
+		beforeTime = sql.NullTime{Time: before.CreatedAt, Valid: true}
+		beforeID = before.ID
+	}
+
+	rows, err := r.db.Query(sqlQuery, userID, query, conversationID, beforeTime, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	results := []*models.MessageSearchResult{}
+	messages := []*models.Message{}
+	for rows.Next() {
+		var snippet string
+		message, err := scanMessage(snippetScanner{rows, &snippet})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		results = append(results, &models.MessageSearchResult{
+			Message: message,
+			Snippet: formatSnippet(snippet, query),
+		})
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, r.attachReactions(messages)
+}
+
+// snippetScanner scans a message row followed by its search snippet
+type snippetScanner struct {
+	row     messageScanner
+	snippet *string
+}
+
+func (s snippetScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.snippet)...)
+}
+
+// formatSnippet turns a ts_headline snippet into HTML, escaping the message
+// text and wrapping the matches in <mark> tags
+func formatSnippet(snippet, query string) string {
+	snippet = html.EscapeString(highlightCJK(snippet, query))
+	return strings.NewReplacer(snippetMarkStart, "<mark>", snippetMarkStop, "</mark>").Replace(snippet)
+}
+
+// highlightCJK marks the Japanese words of query in snippet. ts_headline
+// can't, as the text search parser doesn't split Japanese into words.
+func highlightCJK(snippet, query string) string {
+	if strings.Contains(snippet, snippetMarkStart) {
+		return snippet
+	}
+
+	var words []string
+	start := -1
+	for i, r := range query + " " {
+		switch {
+		case isCJK(r) && start < 0:
+			start = i
+		case !isCJK(r) && start >= 0:
+			words = append(words, query[start:i])
+			start = -1
+		}
+	}
+
+	// Longest first, so a word inside a longer one isn't marked twice
+	sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	for _, word := range words {
+		if !strings.Contains(snippet, snippetMarkStart+word) {
+			snippet = strings.ReplaceAll(snippet, word, snippetMarkStart+word+snippetMarkStop)
+		}
+	}
+	return snippet
+}
+
+// isCJK reports whether r is kana or kanji, the same ranges chat_cjk_terms
+// indexes
+func isCJK(r rune) bool {
+	return (r >= 0x3040 && r <= 0x30ff) || (r >= 0x3400 && r <= 0x4dbf) || (r >= 0x4e00 && r <= 0x9fff) ||
+		(r >= 0xf900 && r <= 0xfaff) || (r >= 0xff66 && r <= 0xff9f)
+}
+
+// GetMeetupConversationID returns the conversation a meetup was proposed in
+func (r *MessageRepository) GetMeetupConversationID(meetupID uuid.UUID) (uuid.UUID, error) {
+	var conversationID uuid.UUID
+	query := `SELECT conversation_id FROM messages WHERE meetup_id = $1 ORDER BY created_at LIMIT 1`
+	err := r.db.QueryRow(query, meetupID).Scan(&conversationID)
+	return conversationID, err
+}
+
+// attachReactions loads the reactions to messages
+func (r *MessageRepository) attachReactions(messages []*models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*models.Message, len(messages))
+	ids := make([]uuid.UUID, 0, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+		ids = append(ids, message.ID)
+	}
+
+	query := `
+        SELECT message_id, emoji, user_id FROM message_reactions
+        WHERE message_id = ANY($1)
+        ORDER BY message_id, MIN(created_at) OVER (PARTITION BY message_id, emoji), emoji, created_at`
+
+	rows, err := r.db.Query(query, pq.Array(uuidStrings(ids)))
+	if err != nil {
+		return fmt.Errorf("failed to get reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, userID uuid.UUID
+		var emoji string
+		if err := rows.Scan(&messageID, &emoji, &userID); err != nil {
+			return fmt.Errorf("failed to scan reaction: %w", err)
+		}
+
+		message := byID[messageID]
+		if n := len(message.Reactions); n > 0 && message.Reactions[n-1].Emoji == emoji {
+			message.Reactions[n-1].UserIDs = append(message.Reactions[n-1].UserIDs, userID)
+		} else {
+			message.Reactions = append(message.Reactions, &models.MessageReaction{Emoji: emoji, UserIDs: []uuid.UUID{userID}})
+		}
+	}
+	return rows.Err()
+}
+
+// GetRecipientIDs returns the users whose inbox messageID is in, the sender
+// included
+func (r *MessageRepository) GetRecipientIDs(messageID uuid.UUID) ([]uuid.UUID, error) {
+	userIDs := []uuid.UUID{}
+	query := `SELECT user_id FROM message_deliveries WHERE message_id = $1`
+	if err := r.db.Select(&userIDs, query, messageID); err != nil {
+		return nil, fmt.Errorf("failed to get recipients: %w", err)
+	}
+	return userIDs, nil
+}
+
+// Edit replaces the text of message with message.MessageText, keeping the
+// old one in its edit history, drops its translations and sets EditedAt. It
+// returns sql.ErrNoRows if the message was deleted for everyone meanwhile.
+func (r *MessageRepository) Edit(message *models.Message) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO message_edits (message_id, message_text, replaced_at)
+        SELECT id, message_text, NOW() FROM messages
+        WHERE id = $1 AND deleted_at IS NULL`
+	result, err := tx.Exec(query, message.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save edit history: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	query = `UPDATE messages SET message_text = $2, edited_at = NOW() WHERE id = $1 RETURNING edited_at`
+	if err := tx.QueryRow(query, message.ID, message.MessageText).Scan(&message.EditedAt); err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_translations WHERE message_id = $1`, message.ID); err != nil {
+		return fmt.Errorf("failed to remove translations: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// DeleteForEveryone clears the content of message, keeping its text in the
+// edit history, drops its reactions and translations and sets DeletedAt. A
+// meetup it proposed that nobody accepted yet is cancelled. It returns
+// sql.ErrNoRows if the message was already deleted.
+func (r *MessageRepository) DeleteForEveryone(message *models.Message) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO message_edits (message_id, message_text, replaced_at)
+        SELECT id, message_text, NOW() FROM messages
+        WHERE id = $1 AND deleted_at IS NULL AND message_text IS NOT NULL`
+	if _, err := tx.Exec(query, message.ID); err != nil {
+		return fmt.Errorf("failed to save edit history: %w", err)
+	}
+
+	query = `
+        UPDATE messages
+        SET deleted_at = NOW(), message_text = NULL, image_url = NULL, cloudinary_public_id = NULL,
+            latitude = NULL, longitude = NULL, location_name = NULL, meetup_id = NULL
+        WHERE id = $1 AND deleted_at IS NULL
+        RETURNING deleted_at`
+	if err := tx.QueryRow(query, message.ID).Scan(&message.DeletedAt); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM message_reactions WHERE message_id = $1`, message.ID); err != nil {
+		return fmt.Errorf("failed to remove reactions: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM message_translations WHERE message_id = $1`, message.ID); err != nil {
+		return fmt.Errorf("failed to remove translations: %w", err)
+	}
+
+	if message.MeetupID != nil {
+		query = `UPDATE meetups SET status = 'cancelled' WHERE id = $1 AND status = 'proposed'`
+		if _, err := tx.Exec(query, *message.MeetupID); err != nil {
+			return fmt.Errorf("failed to cancel meetup: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	message.MessageText = nil
+	message.ImageURL = nil
+	message.CloudinaryPublicID = nil
+	message.Location = nil
+	message.MeetupID = nil
+	message.Meetup = nil
+	message.Reactions = nil
+	message.Translation = nil
+	return nil
+}
+
+// IsImageInUse reports whether a message still shows the image publicID
+func (r *MessageRepository) IsImageInUse(publicID string) (bool, error) {
+	var inUse bool
+	query := `SELECT EXISTS (SELECT 1 FROM messages WHERE cloudinary_public_id = $1)`
+	if err := r.db.QueryRow(query, publicID).Scan(&inUse); err != nil {
+		return false, fmt.Errorf("failed to check image: %w", err)
+	}
+	return inUse, nil
+}
+
+// Hide deletes messageID for userID only
+func (r *MessageRepository) Hide(messageID, userID uuid.UUID) error {
+	query := `
+        INSERT INTO message_hidden (message_id, user_id, hidden_at) VALUES ($1, $2, NOW())
+        ON CONFLICT (user_id, message_id) DO NOTHING`
+	if _, err := r.db.Exec(query, messageID, userID); err != nil {
+		return fmt.Errorf("failed to hide message: %w", err)
+	}
+	return nil
+}
+
+// AddReaction records userID's emoji reaction to messageID. It reports
+// whether the reaction is new.
+func (r *MessageRepository) AddReaction(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	query := `
+        INSERT INTO message_reactions (message_id, user_id, emoji, created_at) VALUES ($1, $2, $3, NOW())
+        ON CONFLICT (message_id, user_id, emoji) DO NOTHING`
+	result, err := r.db.Exec(query, messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("failed to add reaction: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// RemoveReaction takes back userID's emoji reaction to messageID. It reports
+// whether there was one.
+func (r *MessageRepository) RemoveReaction(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	query := `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+	result, err := r.db.Exec(query, messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// GetEdits returns the texts messageID had before its edits, oldest first
+func (r *MessageRepository) GetEdits(messageID uuid.UUID) ([]*models.MessageEdit, error) {
+	edits := []*models.MessageEdit{}
+	query := `
+        SELECT message_text, replaced_at FROM message_edits
+        WHERE message_id = $1
+        ORDER BY replaced_at, id`
+	if err := r.db.Select(&edits, query, messageID); err != nil {
+		return nil, fmt.Errorf("failed to get edit history: %w", err)
+	}
+	return edits, nil
+}
+
+// SaveTranslation caches the translation of messageID into language. It is
+// dropped if the message was edited or deleted since the text was
+// translated, editedAt being when the translated text was set.
+func (r *MessageRepository) SaveTranslation(messageID uuid.UUID, editedAt *time.Time, language, text string) error {
+	query := `
+        INSERT INTO message_translations (message_id, language, translated_text, created_at)
+        SELECT id, $2, $3, NOW() FROM messages
+        WHERE id = $1 AND edited_at IS NOT DISTINCT FROM $4 AND deleted_at IS NULL
+        ON CONFLICT (message_id, language) DO UPDATE SET translated_text = EXCLUDED.translated_text`
+	if _, err := r.db.Exec(query, messageID, language, text, editedAt); err != nil {
+		return fmt.Errorf("failed to save translation: %w", err)
+	}
+	return nil
+}
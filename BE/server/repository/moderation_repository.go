@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"fmt"
+	"tukarkultur/api/models"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type ModerationRepository struct {
+	db *sqlx.DB
+}
+
+func NewModerationRepository(db *sqlx.DB) *ModerationRepository {
+	return &ModerationRepository{db: db}
+}
+
+// CreateFlag records a message the content filter flagged or blocked
+func (r *ModerationRepository) CreateFlag(flag *models.FlaggedMessage) error {
+	query := `
+        INSERT INTO flagged_messages (id, message_id, conversation_id, sender_id, message_text, action, reasons, source, status, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+        RETURNING created_at`
+
+	flag.ID = uuid.New()
+	flag.Status = models.FlagStatusPending
+	if flag.Reasons == nil {
+		flag.Reasons = []string{}
+	}
+
+	err := r.db.QueryRow(
+		query,
+		flag.ID,
+		flag.MessageID,
+		flag.ConversationID,
+		flag.SenderID,
+		flag.MessageText,
+		flag.Action,
+		pq.Array(flag.Reasons),
+		flag.Source,
+		flag.Status,
+	).Scan(&flag.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to flag message: %w", err)
+	}
+	return nil
+}
+
+const flagColumns = `id, message_id, conversation_id, sender_id, message_text, action, reasons, source,
+               status, reviewed_by, reviewed_at, created_at`
+
+func scanFlag(row messageScanner) (*models.FlaggedMessage, error) {
+	flag := &models.FlaggedMessage{}
+	err := row.Scan(
+		&flag.ID,
+		&flag.MessageID,
+		&flag.ConversationID,
+		&flag.SenderID,
+		&flag.MessageText,
+		&flag.Action,
+		pq.Array(&flag.Reasons),
+		&flag.Source,
+		&flag.Status,
+		&flag.ReviewedBy,
+		&flag.ReviewedAt,
+		&flag.CreatedAt,
+	)
+	return flag, err
+}
+
+// GetFlags returns up to limit flagged messages in the given review status,
+// oldest first so moderators work through the queue in order
+func (r *ModerationRepository) GetFlags(status string, limit, offset int) ([]*models.FlaggedMessage, error) {
+	query := `
+        SELECT ` + flagColumns + `
+        FROM flagged_messages
+        WHERE status = $1
+        ORDER BY created_at, id
+        LIMIT $2 OFFSET $3`
+
+	rows, err := r.db.Query(query, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flagged messages: %w", err)
+	}
+	defer rows.Close()
+
+	flags := []*models.FlaggedMessage{}
+	for rows.Next() {
+		flag, err := scanFlag(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan flagged message: %w", err)
+		}
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+// Review records a moderator's decision on a flagged message. The error
+// wraps sql.ErrNoRows if there is no such flag.
+func (r *ModerationRepository) Review(id uuid.UUID, status string, reviewerID uuid.UUID) (*models.FlaggedMessage, error) {
+	query := `
+        UPDATE flagged_messages SET status = $2, reviewed_by = $3, reviewed_at = NOW()
+        WHERE id = $1
+        RETURNING ` + flagColumns
+
+	flag, err := scanFlag(r.db.QueryRow(query, id, status, reviewerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to review flagged message: %w", err)
+	}
+	return flag, nil
+}
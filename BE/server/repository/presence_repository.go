@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// PresenceRepository counts open chat connections per user and API
+// instance in Postgres, so presence holds across instances. Counts of an
+// instance whose heartbeat is older than ttl no longer count.
+type PresenceRepository struct {
+	db *sqlx.DB
+}
+
+func NewPresenceRepository(db *sqlx.DB) *PresenceRepository {
+	return &PresenceRepository{db: db}
+}
+
+// Connect counts a new connection of userID on instanceID and reports
+// whether it is the user's first on any instance
+func (r *PresenceRepository) Connect(instanceID, userID uuid.UUID, ttl time.Duration) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	before, err := r.lockConnections(tx, userID, ttl)
+	if err != nil {
+		return false, err
+	}
+
+	query := `INSERT INTO presence_connections (instance_id, user_id, connections)
+              VALUES ($1, $2, 1)
+              ON CONFLICT (instance_id, user_id) DO UPDATE
+              SET connections = presence_connections.connections + 1, heartbeat_at = NOW()`
+	if _, err := tx.Exec(query, instanceID, userID); err != nil {
+		return false, fmt.Errorf("failed to count connection: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return before == 0, nil
+}
+
+// Disconnect counts a closed connection of userID on instanceID and
+// reports whether it was the user's last on any instance
+func (r *PresenceRepository) Disconnect(instanceID, userID uuid.UUID, ttl time.Duration) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := r.lockConnections(tx, userID, ttl); err != nil {
+		return false, err
+	}
+
+	query := `UPDATE presence_connections SET connections = connections - 1
+              WHERE instance_id = $1 AND user_id = $2`
+	if _, err := tx.Exec(query, instanceID, userID); err != nil {
+		return false, fmt.Errorf("failed to uncount connection: %w", err)
+	}
+	query = `DELETE FROM presence_connections WHERE instance_id = $1 AND user_id = $2 AND connections <= 0`
+	if _, err := tx.Exec(query, instanceID, userID); err != nil {
+		return false, fmt.Errorf("failed to uncount connection: %w", err)
+	}
+
+	after, err := r.countConnections(tx, userID, ttl)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return after == 0, nil
+}
+
+// IsOnline reports whether userID has a connection open on any instance
+func (r *PresenceRepository) IsOnline(userID uuid.UUID, ttl time.Duration) (bool, error) {
+	connections, err := r.countConnections(r.db, userID, ttl)
+	return connections > 0, err
+}
+
+// Heartbeat marks the connections of instanceID as still open and sets
+// their counts to connections, which restores them if they were expired
+// while the instance couldn't reach the database
+func (r *PresenceRepository) Heartbeat(instanceID uuid.UUID, connections map[uuid.UUID]int) error {
+	userIDs := make([]string, 0, len(connections))
+	counts := make([]int64, 0, len(connections))
+	for userID, count := range connections {
+		userIDs = append(userIDs, userID.String())
+		counts = append(counts, int64(count))
+	}
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM presence_connections WHERE instance_id = $1 AND NOT (user_id = ANY($2::uuid[]))`
+	if _, err := tx.Exec(query, instanceID, pq.Array(userIDs)); err != nil {
+		return fmt.Errorf("failed to refresh presence heartbeat: %w", err)
+	}
+	query = `INSERT INTO presence_connections (instance_id, user_id, connections)
+             SELECT $1::uuid, c.user_id, c.connections FROM UNNEST($2::uuid[], $3::int[]) AS c(user_id, connections)
+             JOIN users ON users.id = c.user_id
+             ON CONFLICT (instance_id, user_id) DO UPDATE
+             SET connections = EXCLUDED.connections, heartbeat_at = NOW()`
+	if _, err := tx.Exec(query, instanceID, pq.Array(userIDs), pq.Array(counts)); err != nil {
+		return fmt.Errorf("failed to refresh presence heartbeat: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Expire drops the connections of instances that stopped sending heartbeats
+// for ttl, or all of instanceID's when it shuts down if instanceID isn't
+// uuid.Nil, and returns the users they belonged to with their last
+// heartbeat. Only one instance gets each dropped row.
+func (r *PresenceRepository) Expire(instanceID uuid.UUID, ttl time.Duration) (map[uuid.UUID]time.Time, error) {
+	query := `DELETE FROM presence_connections
+              WHERE heartbeat_at < NOW() - $2 * INTERVAL '1 second' OR instance_id = $1
+              RETURNING user_id, heartbeat_at`
+	rows, err := r.db.Query(query, instanceID, ttl.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire connections: %w", err)
+	}
+	defer rows.Close()
+
+	expired := make(map[uuid.UUID]time.Time)
+	for rows.Next() {
+		var userID uuid.UUID
+		var heartbeatAt time.Time
+		if err := rows.Scan(&userID, &heartbeatAt); err != nil {
+			return nil, err
+		}
+		if heartbeatAt.After(expired[userID]) {
+			expired[userID] = heartbeatAt
+		}
+	}
+	return expired, rows.Err()
+}
+
+// lockConnections serializes connection changes of userID until tx ends,
+// so two instances can't both see themselves as the first or last, and
+// returns how many connections the user has open
+func (r *PresenceRepository) lockConnections(tx *sqlx.Tx, userID uuid.UUID, ttl time.Duration) (int, error) {
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, userID.String()); err != nil {
+		return 0, fmt.Errorf("failed to lock presence: %w", err)
+	}
+	return r.countConnections(tx, userID, ttl)
+}
+
+func (r *PresenceRepository) countConnections(q sqlx.Queryer, userID uuid.UUID, ttl time.Duration) (int, error) {
+	var connections int
+	query := `SELECT COALESCE(SUM(connections), 0) FROM presence_connections
+              WHERE user_id = $1 AND heartbeat_at >= NOW() - $2 * INTERVAL '1 second'`
+	if err := q.QueryRowx(query, userID, ttl.Seconds()).Scan(&connections); err != nil {
+		return 0, fmt.Errorf("failed to count connections: %w", err)
+	}
+	return connections, nil
+}
@@ -1,13 +1,20 @@
 package repository
 
 import (
+	"database/sql"
+	"errors"
 	"time"
 	"tukarkultur/api/models"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// ErrDuplicateUser is returned when an update would give a user the email
+// or username of another
+var ErrDuplicateUser = errors.New("email or username already exists")
+
 type UserRepository struct {
 	db *sqlx.DB
 }
@@ -25,7 +32,7 @@ func (r *UserRepository) Create(user *models.User) error {
             total_interactions, average_rating, updated_at, created_at
         ) VALUES (
             $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18
-        ) RETURNING created_at, updated_at`
+        ) RETURNING role, created_at, updated_at`
 
 	now := time.Now()
 	user.CreatedAt = now
@@ -37,14 +44,14 @@ func (r *UserRepository) Create(user *models.User) error {
 		user.ProfilePictureURL, user.Bio, user.Age, user.City, user.Country, user.Interests,
 		user.Latitude, user.Longitude, user.LocationUpdatedAt,
 		user.TotalInteractions, user.AverageRating, user.UpdatedAt, user.CreatedAt,
-	).Scan(&user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.Role, &user.CreatedAt, &user.UpdatedAt)
 
 	return err
 }
 
 func (r *UserRepository) GetByID(id uuid.UUID) (*models.User, error) {
 	query := `
-        SELECT id, username, email, password_hash, full_name,
+        SELECT id, username, email, email_verified_at, password_hash, role, suspended_at, suspension_reason, full_name,
                profile_picture_url, bio, age, city, country, interests,
                latitude, longitude, location_updated_at,
                total_interactions, average_rating, updated_at, created_at
@@ -52,7 +59,8 @@ func (r *UserRepository) GetByID(id uuid.UUID) (*models.User, error) {
 
 	user := &models.User{}
 	err := r.db.QueryRow(query, id).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.FullName,
+		&user.ID, &user.Username, &user.Email, &user.EmailVerifiedAt, &user.PasswordHash,
+		&user.Role, &user.SuspendedAt, &user.SuspensionReason, &user.FullName,
 		&user.ProfilePictureURL, &user.Bio, &user.Age, &user.City, &user.Country, &user.Interests,
 		&user.Latitude, &user.Longitude, &user.LocationUpdatedAt,
 		&user.TotalInteractions, &user.AverageRating, &user.UpdatedAt, &user.CreatedAt,
@@ -67,7 +75,7 @@ func (r *UserRepository) GetByID(id uuid.UUID) (*models.User, error) {
 
 func (r *UserRepository) GetAll() ([]*models.User, error) {
 	query := `
-        SELECT id, username, email, password_hash, full_name,
+        SELECT id, username, email, email_verified_at, password_hash, role, suspended_at, suspension_reason, full_name,
                profile_picture_url, bio, age, city, country, interests,
                latitude, longitude, location_updated_at,
                total_interactions, average_rating, updated_at, created_at
@@ -83,7 +91,8 @@ func (r *UserRepository) GetAll() ([]*models.User, error) {
 	for rows.Next() {
 		user := &models.User{}
 		err := rows.Scan(
-			&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.FullName,
+			&user.ID, &user.Username, &user.Email, &user.EmailVerifiedAt, &user.PasswordHash,
+			&user.Role, &user.SuspendedAt, &user.SuspensionReason, &user.FullName,
 			&user.ProfilePictureURL, &user.Bio, &user.Age, &user.City, &user.Country, &user.Interests,
 			&user.Latitude, &user.Longitude, &user.LocationUpdatedAt,
 			&user.TotalInteractions, &user.AverageRating, &user.UpdatedAt, &user.CreatedAt,
@@ -97,25 +106,33 @@ func (r *UserRepository) GetAll() ([]*models.User, error) {
 	return users, nil
 }
 
+// Update saves the user's profile. Changing the email unverifies it, and
+// EmailVerifiedAt is updated to match.
 func (r *UserRepository) Update(user *models.User) error {
 	query := `
         UPDATE users SET
             username = $2, email = $3, full_name = $4,
             profile_picture_url = $5, bio = $6, age = $7, city = $8, country = $9,
             interests = $10, latitude = $11, longitude = $12, location_updated_at = $13,
-            updated_at = $14
-        WHERE id = $1`
+            updated_at = $14,
+            email_verified_at = CASE WHEN email = $3 THEN email_verified_at END
+        WHERE id = $1
+        RETURNING email_verified_at`
 
 	user.UpdatedAt = time.Now()
 
-	_, err := r.db.Exec(
+	err := r.db.QueryRow(
 		query,
 		user.ID, user.Username, user.Email, user.FullName,
 		user.ProfilePictureURL, user.Bio, user.Age, user.City, user.Country,
 		user.Interests, user.Latitude, user.Longitude, user.LocationUpdatedAt,
 		user.UpdatedAt,
-	)
+	).Scan(&user.EmailVerifiedAt)
 
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicateUser
+	}
 	return err
 }
 
@@ -137,3 +154,62 @@ func (r *UserRepository) Delete(id uuid.UUID) error {
 	_, err := r.db.Exec(query, id)
 	return err
 }
+
+// Suspend blocks the account until Unsuspend is called
+func (r *UserRepository) Suspend(id uuid.UUID, reason string) error {
+	query := `UPDATE users SET suspended_at = NOW(), suspension_reason = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Exec(query, id, reason)
+	return err
+}
+
+func (r *UserRepository) Unsuspend(id uuid.UUID) error {
+	query := `UPDATE users SET suspended_at = NULL, suspension_reason = NULL, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+func (r *UserRepository) UpdateRole(id uuid.UUID, role string) error {
+	query := `UPDATE users SET role = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Exec(query, id, role)
+	return err
+}
+
+// GetSettings returns the user's settings, or the defaults if never changed
+func (r *UserRepository) GetSettings(userID uuid.UUID) (*models.UserSettings, error) {
+	query := `SELECT user_id, read_receipts, online_status, preferred_language, updated_at FROM user_settings WHERE user_id = $1`
+
+	settings := &models.UserSettings{}
+	err := r.db.QueryRow(query, userID).Scan(&settings.UserID, &settings.ReadReceipts, &settings.OnlineStatus, &settings.PreferredLanguage, &settings.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return models.DefaultUserSettings(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (r *UserRepository) UpdateSettings(settings *models.UserSettings) error {
+	query := `
+        INSERT INTO user_settings (user_id, read_receipts, online_status, preferred_language, updated_at)
+        VALUES ($1, $2, $3, $4, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET
+            read_receipts = EXCLUDED.read_receipts,
+            online_status = EXCLUDED.online_status,
+            preferred_language = EXCLUDED.preferred_language,
+            updated_at = NOW()
+        RETURNING updated_at`
+
+	return r.db.QueryRow(query, settings.UserID, settings.ReadReceipts, settings.OnlineStatus, settings.PreferredLanguage).Scan(&settings.UpdatedAt)
+}
+
+func (r *UserRepository) GetLastSeen(id uuid.UUID) (*time.Time, error) {
+	var lastSeenAt *time.Time
+	err := r.db.QueryRow(`SELECT last_seen_at FROM users WHERE id = $1`, id).Scan(&lastSeenAt)
+	return lastSeenAt, err
+}
+
+func (r *UserRepository) UpdateLastSeen(id uuid.UUID, lastSeenAt time.Time) error {
+	_, err := r.db.Exec(`UPDATE users SET last_seen_at = $2 WHERE id = $1`, id, lastSeenAt)
+	return err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"tukarkultur/api/models"
+	"tukarkultur/api/repository"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const setRoleUsage = "usage: server set-role <email> user|moderator|admin"
+
+// runSetRoleCommand implements `server set-role <email> <role>`. It is how
+// the first admin is created, and how an admin is demoted, since admins
+// can't manage each other through the API.
+func runSetRoleCommand(db *sqlx.DB, args []string) error {
+	if len(args) != 2 || !models.IsValidRole(args[1]) {
+		return errors.New(setRoleUsage)
+	}
+	email, role := args[0], args[1]
+
+	user, err := repository.NewAuthRepository(db).GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if err := repository.NewUserRepository(db).UpdateRole(user.ID, role); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now %s (was %s)\n", user.Email, role, user.Role)
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"tukarkultur/api/chat_socket"
 	"tukarkultur/api/handlers"
+	"tukarkultur/api/middleware"
+	"tukarkultur/api/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,56 +19,109 @@ func SetupRoutes(
 	meetupHandler *handlers.MeetupHandler,
 	interactionHandler *handlers.InteractionHandler,
 	authHandler *handlers.AuthHandler, // Add auth handler
+	adminHandler *handlers.AdminHandler,
+	chatHandler *handlers.ChatHandler,
+	chatHub *chat_socket.Hub,
+	authMiddleware *middleware.AuthMiddleware,
 ) {
-	// Health check endpoint
-	router.GET("/api/v1/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "ok",
-			"message": "Server is running",
-		})
-	})
-
 	// API v1 routes
 	v1 := router.Group("/api/v1")
+
+	// Every v1 route requires a session token except these
+	v1.Use(authMiddleware.RequireAuth(
+		"/api/v1/health",
+		"/api/v1/auth/register",
+		"/api/v1/auth/login",
+		"/api/v1/auth/refresh",
+		"/api/v1/auth/logout", // reports on the token itself, valid or not
+		"/api/v1/auth/password/forgot",
+		"/api/v1/auth/password/reset",
+		"/api/v1/auth/verify",
+		"/api/v1/auth/2fa/verify", // second step of login, authenticated by the challenge token
+		"/api/v1/chat",            // authenticates during the WebSocket handshake or first frame
+	))
 	{
-		// Auth routes (no authentication required)
+		// Health check endpoint
+		v1.GET("/health", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"status":  "ok",
+				"message": "Server is running",
+			})
+		})
+
+		// Auth routes
 		auth := v1.Group("/auth")
 		{
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
+			auth.POST("/refresh", authHandler.Refresh)
 			auth.POST("/logout", authHandler.Logout)
+			auth.GET("/me", authHandler.Me)
+			auth.GET("/validate", authHandler.Validate)
+			auth.GET("/sessions", authHandler.GetSessions)
+			auth.GET("/login-attempts", authHandler.GetFailedLoginAttempts)
+			auth.DELETE("/sessions", authHandler.RevokeOtherSessions)
+			auth.DELETE("/sessions/:id", authHandler.RevokeSession)
+			auth.POST("/password/change", authHandler.ChangePassword)
+			auth.POST("/password/forgot", authHandler.ForgotPassword)
+			auth.POST("/password/reset", authHandler.ResetPassword)
+			auth.GET("/verify", authHandler.VerifyEmail)
+			auth.POST("/verify/resend", authHandler.ResendVerificationEmail)
+			auth.GET("/2fa", authHandler.GetTwoFactorStatus)
+			auth.POST("/2fa/enroll", authHandler.EnrollTwoFactor)
+			auth.POST("/2fa/confirm", authHandler.ConfirmTwoFactor)
+			auth.POST("/2fa/disable", authHandler.DisableTwoFactor)
+			auth.POST("/2fa/verify", authHandler.VerifyTwoFactorLogin)
 		}
 
 		// User routes
 		users := v1.Group("/users")
 		{
-			users.POST("", userHandler.CreateUser)
+			users.POST("", authMiddleware.RequireRole(models.RoleAdmin), userHandler.CreateUser)
 			users.GET("", userHandler.GetAllUsers)
+			users.PUT("/profile", userHandler.UpdateProfile)
+			users.GET("/settings", userHandler.GetSettings)
+			users.PUT("/settings", userHandler.UpdateSettings)
 			users.GET("/:id", userHandler.GetUser)
+			users.GET("/:id/presence", userHandler.GetPresence)
 			users.PUT("/:id", userHandler.UpdateUser)
 			users.PUT("/location/:id", userHandler.UpdateLocation)
 			users.DELETE("/:id", userHandler.DeleteUser)
 			users.POST("/:id/profile-picture", userHandler.UploadProfilePicture)
 		}
 
-		// User routes
+		// Chat routes
 		chat := v1.Group("/chat")
 		{
-			chat.GET("", chat_socket.HandleConnection)
+			chat.GET("", chatHub.HandleConnection)
+			chat.GET("/events", chatHub.HandleEventStream)
+			chat.POST("/frames", chatHub.HandleFrame)
+			chat.GET("/conversations", chatHandler.GetConversations)
+			chat.POST("/conversations", chatHandler.CreateConversation)
+			chat.GET("/conversations/with/:user_id", chatHandler.GetDirectConversation)
+			chat.GET("/conversations/:id", chatHandler.GetConversation)
+			chat.PUT("/conversations/:id", chatHandler.UpdateConversation)
+			chat.PUT("/conversations/:id/translation", chatHandler.UpdateTranslation)
+			chat.PUT("/conversations/:id/owner", chatHandler.TransferOwnership)
+			chat.GET("/conversations/:id/messages", chatHandler.GetMessages)
+			chat.POST("/conversations/:id/participants", chatHandler.AddParticipants)
+			chat.DELETE("/conversations/:id/participants/:user_id", chatHandler.RemoveParticipant)
+			chat.GET("/search", chatHandler.SearchMessages)
+			chat.POST("/images", chatHandler.UploadImage)
 		}
 
 		// Friend routes
 		friends := v1.Group("/friends")
 		{
 			friends.GET("", friendHandler.GetAllFriends)
-			friends.POST("", friendHandler.CreateFriend)
+			friends.POST("", authMiddleware.RequireRole(models.RoleAdmin), friendHandler.CreateFriend)
 			friends.DELETE("", friendHandler.DeleteFriend)
 		}
 
 		// Friend request routes
 		friendRequests := v1.Group("/friend-requests")
 		{
-			friendRequests.POST("", friendHandler.SendFriendRequest)
+			friendRequests.POST("", authMiddleware.RequireVerifiedEmail(), friendHandler.SendFriendRequest)
 			friendRequests.PUT("/:id", friendHandler.RespondToFriendRequest)
 			friendRequests.GET("/received", friendHandler.GetReceivedFriendRequests)
 			friendRequests.GET("/sent", friendHandler.GetSentFriendRequests)
@@ -75,7 +130,7 @@ func SetupRoutes(
 		// Meetup routes
 		meetups := v1.Group("/meetups")
 		{
-			meetups.POST("", meetupHandler.CreateMeetup)
+			meetups.POST("", authMiddleware.RequireVerifiedEmail(), meetupHandler.CreateMeetup)
 			meetups.GET("", meetupHandler.GetAllMeetups)
 			meetups.GET("/:id", meetupHandler.GetMeetup)
 			meetups.GET("/user/:id", meetupHandler.GetMeetupsByUserID)
@@ -98,6 +153,20 @@ func SetupRoutes(
 			interactions.DELETE("/:id", interactionHandler.DeleteInteraction)
 		}
 
+		// Moderation routes
+		admin := v1.Group("/admin", authMiddleware.RequireRole(models.RoleModerator))
+		{
+			admin.GET("/users", adminHandler.GetUsers)
+			admin.PUT("/users/:id/suspend", adminHandler.SuspendUser)
+			admin.PUT("/users/:id/unsuspend", adminHandler.UnsuspendUser)
+			admin.PUT("/users/:id/role", authMiddleware.RequireRole(models.RoleAdmin), adminHandler.UpdateUserRole)
+			admin.DELETE("/meetups/:id", adminHandler.DeleteMeetup)
+			admin.DELETE("/interactions/:id", adminHandler.DeleteInteraction)
+			admin.GET("/messages/:id/history", adminHandler.GetMessageHistory)
+			admin.GET("/flagged-messages", adminHandler.GetFlaggedMessages)
+			admin.PUT("/flagged-messages/:id", adminHandler.ReviewFlaggedMessage)
+		}
+
 		// Gemini AI routes
 		gemini := v1.Group("/gemini")
 		{
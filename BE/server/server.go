@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 	"tukarkultur/api/chat_socket"
 	"tukarkultur/api/database"
 	"tukarkultur/api/handlers"
+	"tukarkultur/api/middleware"
 	"tukarkultur/api/repository"
 	"tukarkultur/api/routes"
 	"tukarkultur/api/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
 )
 
@@ -38,35 +48,76 @@ func main() {
 	}
 	defer db.Close()
 
+	// `server migrate ...` manages the schema and exits without serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(db, os.Args[2:]); err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		return
+	}
+
+	// `server set-role <email> <role>` changes a user's role and exits
+	if len(os.Args) > 1 && os.Args[1] == "set-role" {
+		if err := runSetRoleCommand(db, os.Args[2:]); err != nil {
+			log.Fatal("Setting role failed: ", err)
+		}
+		return
+	}
+
+	if err := migrateOnStart(db); err != nil {
+		log.Fatal("Failed to migrate database: ", err)
+	}
+
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db)
 	friendRepo := repository.NewFriendRepository(db)
 	meetupRepo := repository.NewMeetupRepository(db)
 	interactionRepo := repository.NewInteractionRepository(db)
 	authRepo := repository.NewAuthRepository(db)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	conversationRepo := repository.NewConversationRepository(db)
+	moderationRepo := repository.NewModerationRepository(db)
+	presenceRepo := repository.NewPresenceRepository(db)
 
 	// Initialize AI services
 	geminiService := services.NewGeminiService()
 	openaiService := services.NewOpenAIService()
 	cloudinaryService := services.NewCloudinaryService()
+	mailer := services.NewMailer()
+	totpService := services.NewTOTPService()
+	presenceService := services.NewPresenceService(userRepo, friendRepo, presenceRepo)
+
+	emailVerifier, err := handlers.NewEmailVerifier(authRepo, mailer)
+	if err != nil {
+		log.Fatal("Failed to set up email verification: ", err)
+	}
 
 	// Initialize handlers
-	userHandler := handlers.NewUserHandler(userRepo, cloudinaryService)
+	userHandler := handlers.NewUserHandler(userRepo, cloudinaryService, presenceService, emailVerifier)
 	friendHandler := handlers.NewFriendHandler(friendRepo, userRepo)
 	meetupHandler := handlers.NewMeetupHandler(meetupRepo)
 	interactionHandler := handlers.NewInteractionHandler(interactionRepo, meetupRepo)
 	geminiHandler := handlers.NewGeminiHandler(geminiService)
 	openaiHandler := handlers.NewOpenAIHandler(openaiService)
-	authHandler := handlers.NewAuthHandler(authRepo)
+	authHandler := handlers.NewAuthHandler(authRepo, loginAttemptRepo, mailer, totpService, emailVerifier)
+	adminHandler := handlers.NewAdminHandler(userRepo, authRepo, meetupRepo, interactionRepo, messageRepo, moderationRepo)
+	chatHandler := handlers.NewChatHandler(messageRepo, conversationRepo, meetupRepo, cloudinaryService)
+
+	// Initialize middleware
+	authMiddleware := middleware.NewAuthMiddleware(authRepo, userRepo)
 
 	// Setup Gin router
 	router := gin.Default()
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
 
 	// CORS middleware (simple version)
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Device-Name")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -76,13 +127,138 @@ func main() {
 		c.Next()
 	})
 
-	chat_socket.Run()
+	chatPubSub, err := newChatPubSub(db, databaseURL)
+	if err != nil {
+		log.Fatal("Failed to start chat pub/sub: ", err)
+	}
+	chatFilter, err := newChatContentFilter(geminiService, openaiService)
+	if err != nil {
+		log.Fatal("Failed to load chat content filter: ", err)
+	}
+	chatTranslator, err := newChatTranslator(geminiService, openaiService)
+	if err != nil {
+		log.Fatal("Failed to set up chat translation: ", err)
+	}
+	chatHub := chat_socket.NewHub(
+		messageRepo,
+		userRepo,
+		meetupRepo,
+		conversationRepo,
+		moderationRepo,
+		presenceService,
+		cloudinaryService,
+		chatFilter,
+		chatTranslator,
+		chatPubSub,
+		authMiddleware,
+	)
+	go chatHub.Run()
+	go presenceService.Run()
 
 	// Setup routes
-	routes.SetupRoutes(router, userHandler, geminiHandler, openaiHandler, friendHandler, meetupHandler, interactionHandler, authHandler)
+	routes.SetupRoutes(router, userHandler, geminiHandler, openaiHandler, friendHandler, meetupHandler, interactionHandler, authHandler, adminHandler, chatHandler, chatHub, authMiddleware)
 
 	// Start server
-	log.Printf("Server starting on port %s", port)
-	log.Printf("Health check: http://localhost:%s/api/v1/health", port)
-	log.Fatal(router.Run(":" + port))
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+	// Event streams never finish on their own; closing the chat connections
+	// ends them so Shutdown doesn't wait out its timeout
+	srv.RegisterOnShutdown(chatHub.Shutdown)
+
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		log.Printf("Health check: http://localhost:%s/api/v1/health", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Server error: ", err)
+		}
+	}()
+
+	// Wait for an interrupt, then stop accepting requests and close the
+	// chat connections (which Shutdown doesn't track once hijacked, and
+	// would wait for as event streams)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down server...")
+
+	// Take this instance's users offline while their friends can still be
+	// told through the hub
+	presenceService.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+	chatHub.Shutdown()
+
+	log.Println("Server stopped")
+}
+
+// trustedProxies returns the comma-separated proxy addresses or CIDRs in
+// TRUSTED_PROXIES. Only these may set the client IP through X-Forwarded-For,
+// which login throttling and session IPs rely on; with none configured the
+// connecting address is used.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// newChatPubSub picks the chat hub's pub/sub backend from CHAT_PUBSUB:
+// "postgres" to share chat between instances through LISTEN/NOTIFY, or
+// "memory" (the default) for a single instance.
+func newChatPubSub(db *sqlx.DB, databaseURL string) (chat_socket.PubSub, error) {
+	switch backend := os.Getenv("CHAT_PUBSUB"); backend {
+	case "", "memory":
+		return chat_socket.NewLocalPubSub(), nil
+	case "postgres":
+		return chat_socket.NewPostgresPubSub(db, databaseURL)
+	default:
+		return nil, fmt.Errorf("unknown CHAT_PUBSUB backend %q", backend)
+	}
+}
+
+// newChatContentFilter builds the chat content filter: the word lists, then
+// the AI moderation picked by CHAT_AI_MODERATION ("openai" or "gemini"),
+// if any.
+func newChatContentFilter(gemini *services.GeminiService, openai *services.OpenAIService) (services.ContentFilter, error) {
+	wordLists, err := services.NewWordListFilter()
+	if err != nil {
+		return nil, err
+	}
+	filter := services.FilterChain{wordLists}
+
+	switch provider := os.Getenv("CHAT_AI_MODERATION"); provider {
+	case "":
+	case "openai":
+		filter = append(filter, services.NewOpenAIModerationFilter(openai))
+	case "gemini":
+		filter = append(filter, services.NewGeminiModerationFilter(gemini))
+	default:
+		return nil, fmt.Errorf("unknown CHAT_AI_MODERATION provider %q", provider)
+	}
+	return filter, nil
+}
+
+// newChatTranslator builds the translator of chat messages picked by
+// CHAT_TRANSLATION ("openai" or "gemini"), or none if it is unset, in which
+// case messages are never translated
+func newChatTranslator(gemini *services.GeminiService, openai *services.OpenAIService) (services.Translator, error) {
+	switch provider := os.Getenv("CHAT_TRANSLATION"); provider {
+	case "":
+		return nil, nil
+	case "openai":
+		return services.NewOpenAITranslator(openai), nil
+	case "gemini":
+		return services.NewGeminiTranslator(gemini), nil
+	default:
+		return nil, fmt.Errorf("unknown CHAT_TRANSLATION provider %q", provider)
+	}
 }
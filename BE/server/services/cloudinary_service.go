@@ -2,8 +2,13 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"mime/multipart"
+	"net/url"
 	"os"
+	"path"
+	"strings"
 
 	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
@@ -29,3 +34,46 @@ func (s *CloudinaryService) UploadImage(file multipart.File, folder string) (*up
 	}
 	return s.cld.Upload.Upload(ctx, file, uploadParams)
 }
+
+// DeleteImage permanently removes an uploaded image and purges it from the
+// CDN cache
+func (s *CloudinaryService) DeleteImage(publicID string) error {
+	if s.cld == nil {
+		return errors.New("cloudinary is not configured")
+	}
+
+	invalidate := true
+	result, err := s.cld.Upload.Destroy(context.Background(), uploader.DestroyParams{
+		PublicID:   publicID,
+		Invalidate: &invalidate,
+	})
+	if err != nil {
+		return err
+	}
+	// "not found" means it is gone already
+	if result.Error.Message != "" {
+		return fmt.Errorf("failed to delete image %s: %s", publicID, result.Error.Message)
+	}
+	return nil
+}
+
+// IsOwnImage reports whether imageURL is the delivery URL of the image
+// publicID uploaded to this account, so clients can't pass off arbitrary
+// URLs as uploaded images
+func (s *CloudinaryService) IsOwnImage(imageURL, publicID string) bool {
+	if s.cld == nil || publicID == "" {
+		return false
+	}
+
+	parsed, err := url.Parse(imageURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host != "res.cloudinary.com" {
+		return false
+	}
+
+	prefix := "/" + s.cld.Config.Cloud.CloudName + "/image/upload/"
+	if !strings.HasPrefix(parsed.Path, prefix) {
+		return false
+	}
+	rest := strings.TrimSuffix(parsed.Path, path.Ext(parsed.Path))
+	return strings.HasSuffix(rest, "/"+publicID)
+}
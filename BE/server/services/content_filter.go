@@ -0,0 +1,293 @@
+package services
+
+import (
+	"bufio"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"tukarkultur/api/models"
+	"unicode"
+)
+
+// ContentFilter checks chat text before it is sent
+type ContentFilter interface {
+	Check(text string) (*models.ModerationResult, error)
+}
+
+// FilterChain runs its filters in order, each on the text as masked by the
+// ones before, and returns the strictest verdict. It stops at the first
+// block. A filter that fails is skipped, so chat keeps working when an AI
+// service is unreachable.
+type FilterChain []ContentFilter
+
+func (c FilterChain) Check(text string) (*models.ModerationResult, error) {
+	result := &models.ModerationResult{Action: models.ModerationAllow, Text: text}
+	for _, filter := range c {
+		verdict, err := filter.Check(result.Text)
+		if err != nil {
+			log.Println("Content Filter: ", err)
+			continue
+		}
+
+		result.Text = verdict.Text
+		result.Reasons = append(result.Reasons, verdict.Reasons...)
+		if models.ModerationSeverity(verdict.Action) > models.ModerationSeverity(result.Action) {
+			result.Action = verdict.Action
+			result.Source = verdict.Source
+		}
+		if result.Action == models.ModerationBlock {
+			break
+		}
+	}
+	return result, nil
+}
+
+//go:embed wordlists/*.txt
+var defaultWordLists embed.FS
+
+// wordRule is one line of a word list
+type wordRule struct {
+	locale string
+	action string
+	word   []rune // lower case
+	// Japanese has no spaces between words, so its words match anywhere
+	anywhere bool
+}
+
+// WordListFilter masks, flags or blocks texts containing the words of its
+// per-locale lists. Latin words match whole words regardless of case.
+type WordListFilter struct {
+	rules []wordRule
+}
+
+// NewWordListFilter loads the word lists of CHAT_WORDLIST_LOCALES (all of
+// them if unset), named <locale>.txt, from CHAT_WORDLIST_DIR or the lists
+// built in.
+func NewWordListFilter() (*WordListFilter, error) {
+	var lists fs.FS = defaultWordLists
+	dir := "wordlists"
+	if custom := os.Getenv("CHAT_WORDLIST_DIR"); custom != "" {
+		lists, dir = os.DirFS(custom), "."
+	}
+
+	var locales []string
+	if value := os.Getenv("CHAT_WORDLIST_LOCALES"); value != "" {
+		for _, locale := range strings.Split(value, ",") {
+			locales = append(locales, strings.TrimSpace(locale))
+		}
+	} else {
+		files, err := fs.Glob(lists, path.Join(dir, "*.txt"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			locales = append(locales, strings.TrimSuffix(path.Base(file), ".txt"))
+		}
+	}
+
+	filter := &WordListFilter{}
+	for _, locale := range locales {
+		rules, err := loadWordList(lists, path.Join(dir, locale+".txt"), locale)
+		if err != nil {
+			return nil, err
+		}
+		filter.rules = append(filter.rules, rules...)
+	}
+	return filter, nil
+}
+
+func loadWordList(lists fs.FS, name, locale string) ([]wordRule, error) {
+	file, err := lists.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open word list: %w", err)
+	}
+	defer file.Close()
+
+	var rules []wordRule
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		action, word, _ := strings.Cut(text, " ")
+		word = strings.TrimSpace(word)
+		switch action {
+		case models.ModerationMask, models.ModerationFlag, models.ModerationBlock:
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown action %q", name, line, action)
+		}
+		if word == "" {
+			return nil, fmt.Errorf("%s:%d: missing word", name, line)
+		}
+
+		rule := wordRule{locale: locale, action: action, word: lowerRunes(word)}
+		for _, r := range rule.word {
+			if unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han) {
+				rule.anywhere = true
+				break
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+func (f *WordListFilter) Check(text string) (*models.ModerationResult, error) {
+	result := &models.ModerationResult{Action: models.ModerationAllow, Text: text, Source: "wordlist"}
+
+	original := []rune(text)
+	lower := lowerRunes(text)
+
+	masked := false
+	for _, rule := range f.rules {
+		matches := rule.find(lower)
+		if len(matches) == 0 {
+			continue
+		}
+
+		result.Reasons = append(result.Reasons, rule.locale+": "+string(rule.word))
+		if models.ModerationSeverity(rule.action) > models.ModerationSeverity(result.Action) {
+			result.Action = rule.action
+		}
+		if rule.action == models.ModerationMask {
+			for _, start := range matches {
+				for i := start; i < start+len(rule.word); i++ {
+					if !unicode.IsSpace(original[i]) {
+						original[i] = '*'
+					}
+				}
+			}
+			masked = true
+		}
+	}
+
+	if masked {
+		result.Text = string(original)
+	}
+	return result, nil
+}
+
+// find returns where the rule's word starts in text
+func (rule wordRule) find(text []rune) []int {
+	var matches []int
+	for start := 0; start+len(rule.word) <= len(text); start++ {
+		if !runesEqual(text[start:start+len(rule.word)], rule.word) {
+			continue
+		}
+		if !rule.anywhere {
+			end := start + len(rule.word)
+			if (start > 0 && isWordRune(text[start-1])) || (end < len(text) && isWordRune(text[end])) {
+				continue
+			}
+		}
+		matches = append(matches, start)
+	}
+	return matches
+}
+
+// lowerRunes lower cases text rune by rune, so indexes match the original
+func lowerRunes(text string) []rune {
+	runes := []rune(text)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+func runesEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// aiModerationPrompt asks for a verdict on the message appended to it
+const aiModerationPrompt = `You moderate a chat app where people from Indonesia, Japan and elsewhere meet for cultural exchange. ` +
+	`Messages may be in Indonesian, English or Japanese. Judge the message below and reply with JSON only: ` +
+	`{"action": "allow" | "flag" | "block", "reason": "<a few words>"}. ` +
+	`block: threats, hate speech, sexual harassment, or sharing someone's private information. ` +
+	`flag: insults, unwanted advances, scams, or anything else a moderator should look at. ` +
+	`allow: everything else, including friendly banter and swearing not aimed at anyone.
+
+Message:
+`
+
+// AIModerationFilter asks a language model whether a text should be
+// flagged or blocked. It never masks.
+type AIModerationFilter struct {
+	complete func(prompt string) (string, error)
+}
+
+// NewOpenAIModerationFilter moderates through the OpenAI chat API
+func NewOpenAIModerationFilter(openai *OpenAIService) *AIModerationFilter {
+	return &AIModerationFilter{complete: func(prompt string) (string, error) {
+		response, err := openai.GenerateChat(&models.OpenAIChatRequest{
+			Messages:    []models.OpenAIChatMessage{{Role: "user", Content: prompt}},
+			MaxTokens:   60,
+			Temperature: 0.1,
+		})
+		if err != nil {
+			return "", err
+		}
+		return response.Response, nil
+	}}
+}
+
+// NewGeminiModerationFilter moderates through the Gemini API
+func NewGeminiModerationFilter(gemini *GeminiService) *AIModerationFilter {
+	return &AIModerationFilter{complete: func(prompt string) (string, error) {
+		response, err := gemini.GenerateChat(&models.ChatRequest{
+			Messages: []models.ChatMessage{{Role: "user", Content: prompt}},
+		})
+		if err != nil {
+			return "", err
+		}
+		return response.Response, nil
+	}}
+}
+
+func (f *AIModerationFilter) Check(text string) (*models.ModerationResult, error) {
+	response, err := f.complete(aiModerationPrompt + text)
+	if err != nil {
+		return nil, fmt.Errorf("AI moderation failed: %w", err)
+	}
+
+	// Models like to wrap JSON in prose or code fences
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("AI moderation gave no verdict")
+	}
+
+	var verdict struct {
+		Action string `json:"action"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(response[start:end+1]), &verdict); err != nil {
+		return nil, fmt.Errorf("AI moderation gave an invalid verdict: %w", err)
+	}
+
+	result := &models.ModerationResult{Action: models.ModerationAllow, Text: text, Source: "ai"}
+	switch verdict.Action {
+	case models.ModerationAllow:
+	case models.ModerationFlag, models.ModerationBlock:
+		result.Action = verdict.Action
+		result.Reasons = []string{"ai: " + verdict.Reason}
+	default:
+		return nil, fmt.Errorf("AI moderation gave an unknown action %q", verdict.Action)
+	}
+	return result, nil
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MailMessage is a plain-text email
+type MailMessage struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Mailer sends transactional emails (password resets, verification, ...)
+type Mailer interface {
+	Send(msg *MailMessage) error
+}
+
+// NewMailer returns an SMTP mailer when SMTP_HOST is configured, otherwise an
+// outbox mailer that writes to MAIL_OUTBOX_PATH (or the log when unset).
+func NewMailer() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		log.Println("SMTP_HOST not set, emails will be written to the outbox")
+		return NewOutboxMailer(os.Getenv("MAIL_OUTBOX_PATH"))
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return NewSMTPMailer(
+		host,
+		port,
+		os.Getenv("SMTP_USERNAME"),
+		os.Getenv("SMTP_PASSWORD"),
+		os.Getenv("MAIL_FROM"),
+	)
+}
+
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	if from == "" {
+		from = username
+	}
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (m *SMTPMailer) Send(msg *MailMessage) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", m.from)
+	fmt.Fprintf(&body, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&body, "Subject: %s\r\n", msg.Subject)
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	body.WriteString(msg.Body)
+
+	if err := smtp.SendMail(m.host+":"+m.port, auth, m.from, []string{msg.To}, []byte(body.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// OutboxMailer keeps sent messages in memory and appends them as JSON lines
+// to a file (or the log when no path is given). Useful for development and
+// for tests that need to read back the emails a request produced.
+type OutboxMailer struct {
+	path     string
+	mu       sync.Mutex
+	messages []MailMessage
+}
+
+func NewOutboxMailer(path string) *OutboxMailer {
+	return &OutboxMailer{path: path}
+}
+
+func (m *OutboxMailer) Send(msg *MailMessage) error {
+	sent := *msg
+	sent.SentAt = time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, sent)
+
+	if m.path == "" {
+		log.Printf("Outbox email to %s: %s\n%s", sent.To, sent.Subject, sent.Body)
+		return nil
+	}
+
+	line, err := json.Marshal(sent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal email: %w", err)
+	}
+
+	file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open outbox: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	return nil
+}
+
+// Messages returns a copy of every message sent through this mailer
+func (m *OutboxMailer) Messages() []MailMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MailMessage(nil), m.messages...)
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+	"tukarkultur/api/models"
+	"tukarkultur/api/repository"
+
+	"github.com/google/uuid"
+)
+
+// PresenceListener receives a user's presence along with the friends who
+// should be told about it
+type PresenceListener func(presence models.Presence, friendIDs []uuid.UUID)
+
+const (
+	// Interval at which an instance confirms its connections are open
+	presenceHeartbeatPeriod = 30 * time.Second
+
+	// How long connections count without a heartbeat, after which the
+	// instance is taken to have stopped
+	presenceTTL = 3 * presenceHeartbeatPeriod
+)
+
+// PresenceService tracks who is online from their chat connections. A user
+// is online while at least one connection is open on any instance; when the
+// last one closes the time is persisted as their last_seen_at. Changes are
+// announced to the user's friends unless they turned their online status
+// off.
+//
+// Each instance counts its connections in Postgres and refreshes them with
+// a heartbeat while Run is going. Connections of an instance that stopped
+// without closing them expire after presenceTTL, and their users go offline
+// as of the last heartbeat.
+type PresenceService struct {
+	userRepo     *repository.UserRepository
+	friendRepo   *repository.FriendRepository
+	presenceRepo *repository.PresenceRepository
+	instanceID   uuid.UUID
+
+	// Held around every change to this instance's counts, here and in the
+	// database, so a heartbeat doesn't write back a count being changed
+	mu          sync.Mutex
+	connections map[uuid.UUID]int
+	stopped     bool
+	listener    PresenceListener
+
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPresenceService creates a new presence service instance
+func NewPresenceService(userRepo *repository.UserRepository, friendRepo *repository.FriendRepository, presenceRepo *repository.PresenceRepository) *PresenceService {
+	return &PresenceService{
+		userRepo:     userRepo,
+		friendRepo:   friendRepo,
+		presenceRepo: presenceRepo,
+		instanceID:   uuid.New(),
+		connections:  make(map[uuid.UUID]int),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// OnChange sets the function presence changes are announced through
+func (s *PresenceService) OnChange(listener PresenceListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listener = listener
+}
+
+// Run sends this instance's heartbeat and expires the connections of
+// instances that stopped, until Stop is called
+func (s *PresenceService) Run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(presenceHeartbeatPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			err := s.presenceRepo.Heartbeat(s.instanceID, s.connections)
+			s.mu.Unlock()
+			if err != nil {
+				log.Printf("Error sending presence heartbeat: %v", err)
+			}
+			s.expire(uuid.Nil)
+
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Stop ends Run and drops this instance's connections, so its users go
+// offline right away unless they are connected elsewhere. Connections
+// closing afterwards are no longer counted.
+func (s *PresenceService) Stop() {
+	s.stopOnce.Do(func() {
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+
+		close(s.quit)
+		<-s.done
+		s.expire(s.instanceID)
+	})
+}
+
+// Connect records a new connection of userID
+func (s *PresenceService) Connect(userID uuid.UUID) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.connections[userID]++
+	cameOnline, err := s.presenceRepo.Connect(s.instanceID, userID, presenceTTL)
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Error counting connection of user %s: %v", userID, err)
+		return
+	}
+	if cameOnline {
+		s.announce(models.Presence{UserID: userID, Online: true}, false)
+	}
+}
+
+// Disconnect records that a connection of userID closed
+func (s *PresenceService) Disconnect(userID uuid.UUID) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.connections[userID]--
+	if s.connections[userID] <= 0 {
+		delete(s.connections, userID)
+	}
+	wentOffline, err := s.presenceRepo.Disconnect(s.instanceID, userID, presenceTTL)
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Error uncounting connection of user %s: %v", userID, err)
+		return
+	}
+	if wentOffline {
+		s.wentOffline(userID, time.Now())
+	}
+}
+
+// IsOnline reports whether userID has a connection open on any instance
+func (s *PresenceService) IsOnline(userID uuid.UUID) (bool, error) {
+	return s.presenceRepo.IsOnline(userID, presenceTTL)
+}
+
+// expire drops expired connections, or all of instanceID's if it isn't
+// uuid.Nil, and announces the users left without any as offline
+func (s *PresenceService) expire(instanceID uuid.UUID) {
+	expired, err := s.presenceRepo.Expire(instanceID, presenceTTL)
+	if err != nil {
+		log.Printf("Error expiring presence: %v", err)
+		return
+	}
+
+	for userID, lastSeenAt := range expired {
+		online, err := s.IsOnline(userID)
+		if err != nil {
+			log.Printf("Error loading presence of user %s: %v", userID, err)
+			continue
+		}
+		if !online {
+			s.wentOffline(userID, lastSeenAt)
+		}
+	}
+}
+
+// wentOffline persists userID's last seen time and announces them offline
+func (s *PresenceService) wentOffline(userID uuid.UUID, lastSeenAt time.Time) {
+	if err := s.userRepo.UpdateLastSeen(userID, lastSeenAt); err != nil {
+		log.Printf("Error saving last seen of user %s: %v", userID, err)
+	}
+	s.announce(models.Presence{UserID: userID, LastSeenAt: &lastSeenAt}, false)
+}
+
+// Get returns userID's presence as seen by viewerID. Users who turned their
+// online status off always look offline to others, with no last seen time.
+func (s *PresenceService) Get(userID, viewerID uuid.UUID) (*models.Presence, error) {
+	lastSeenAt, err := s.userRepo.GetLastSeen(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	presence := &models.Presence{UserID: userID}
+	if userID != viewerID {
+		settings, err := s.userRepo.GetSettings(userID)
+		if err != nil {
+			return nil, err
+		}
+		if !settings.OnlineStatus {
+			return presence, nil
+		}
+	}
+
+	if presence.Online, err = s.IsOnline(userID); err != nil {
+		return nil, err
+	}
+	presence.LastSeenAt = lastSeenAt
+	return presence, nil
+}
+
+// Refresh re-announces userID's presence after their settings changed, so
+// friends see them go offline when they hide their online status and come
+// back when they show it again
+func (s *PresenceService) Refresh(userID uuid.UUID) {
+	online, err := s.IsOnline(userID)
+	if err != nil {
+		log.Printf("Error loading presence of user %s: %v", userID, err)
+		return
+	}
+	presence := models.Presence{UserID: userID, Online: online}
+	if !presence.Online {
+		lastSeenAt, err := s.userRepo.GetLastSeen(userID)
+		if err != nil {
+			log.Printf("Error loading last seen of user %s: %v", userID, err)
+			return
+		}
+		presence.LastSeenAt = lastSeenAt
+	}
+	s.announce(presence, true)
+}
+
+// announce sends presence to the user's friends. If the user hides their
+// online status nothing is sent, or with force an anonymous offline presence
+// is sent instead.
+func (s *PresenceService) announce(presence models.Presence, force bool) {
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+	if listener == nil {
+		return
+	}
+
+	settings, err := s.userRepo.GetSettings(presence.UserID)
+	if err != nil {
+		log.Printf("Error loading settings of user %s: %v", presence.UserID, err)
+		return
+	}
+	if !settings.OnlineStatus {
+		if !force {
+			return
+		}
+		presence = models.Presence{UserID: presence.UserID}
+	}
+
+	friendIDs, err := s.friendRepo.GetFriendIDs(presence.UserID)
+	if err != nil {
+		log.Printf("Error loading friends of user %s: %v", presence.UserID, err)
+		return
+	}
+	if len(friendIDs) > 0 {
+		listener(presence, friendIDs)
+	}
+}
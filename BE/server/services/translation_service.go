@@ -0,0 +1,73 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"tukarkultur/api/models"
+)
+
+// Translator translates chat text into a language of models.ChatLanguages
+type Translator interface {
+	Translate(text, language string) (string, error)
+}
+
+// translationPrompt asks for the message appended to it in the language
+// filled in for both verbs
+const translationPrompt = `You translate messages in a chat app where people from Indonesia, Japan and elsewhere meet for cultural exchange. ` +
+	`Translate the message below into %s, keeping its tone, emoji and names. ` +
+	`If it is already in %s, repeat it unchanged. Reply with the translation only.
+
+Message:
+`
+
+// AITranslator translates through a language model
+type AITranslator struct {
+	complete func(prompt string) (string, error)
+}
+
+// NewOpenAITranslator translates through the OpenAI chat API
+func NewOpenAITranslator(openai *OpenAIService) *AITranslator {
+	return &AITranslator{complete: func(prompt string) (string, error) {
+		response, err := openai.GenerateChat(&models.OpenAIChatRequest{
+			Messages:    []models.OpenAIChatMessage{{Role: "user", Content: prompt}},
+			MaxTokens:   4096, // room for a message of models.MaxMessageTextLength
+			Temperature: 0.2,
+		})
+		if err != nil {
+			return "", err
+		}
+		return response.Response, nil
+	}}
+}
+
+// NewGeminiTranslator translates through the Gemini API
+func NewGeminiTranslator(gemini *GeminiService) *AITranslator {
+	return &AITranslator{complete: func(prompt string) (string, error) {
+		response, err := gemini.GenerateChat(&models.ChatRequest{
+			Messages: []models.ChatMessage{{Role: "user", Content: prompt}},
+		})
+		if err != nil {
+			return "", err
+		}
+		return response.Response, nil
+	}}
+}
+
+func (t *AITranslator) Translate(text, language string) (string, error) {
+	name, ok := models.ChatLanguages[language]
+	if !ok {
+		return "", fmt.Errorf("unsupported language %q", language)
+	}
+
+	response, err := t.complete(fmt.Sprintf(translationPrompt, name, name) + text)
+	if err != nil {
+		return "", fmt.Errorf("translation failed: %w", err)
+	}
+
+	translation := strings.TrimSpace(response)
+	if translation == "" {
+		return "", errors.New("translation came back empty")
+	}
+	return translation, nil
+}